@@ -16,6 +16,10 @@ func Factory(conf *logical.BackendConfig) (logical.Backend, error) {
 
 // Backend returns a new Backend framework struct
 func Backend() *framework.Backend {
+	return newBackend().Backend
+}
+
+func newBackend() *backend {
 	var b backend
 	b.Backend = &framework.Backend{
 		Help: strings.TrimSpace(backendHelp),
@@ -25,6 +29,7 @@ func Backend() *framework.Backend {
 				"config/*",
 				"revoke/*",
 				"crl/rotate",
+				"tidy",
 			},
 			Unauthenticated: []string{
 				"cert/*",
@@ -32,20 +37,37 @@ func Backend() *framework.Backend {
 				"ca",
 				"crl/pem",
 				"crl",
+				"response_signing_key",
 			},
 		},
 
 		Paths: []*framework.Path{
+			pathListRoles(&b),
 			pathRoles(&b),
+			pathRoleOpenSSL(&b),
 			pathConfigCA(&b),
+			pathConfigCAEscrowToken(&b),
+			pathConfigCAEscrow(&b),
 			pathConfigCRL(&b),
+			pathConfigIssuance(&b),
+			pathConfigNotifications(&b),
+			pathConfigResponseSigning(&b),
+			pathConfigSharedKey(&b),
+			pathConfigURLs(&b),
+			pathCSRInfo(&b),
 			pathIssue(&b),
+			pathGenerateCSR(&b),
+			pathInstallCert(&b),
 			pathRotateCRL(&b),
 			pathFetchCA(&b),
 			pathFetchCRL(&b),
 			pathFetchCRLViaCertPath(&b),
 			pathFetchValid(&b),
+			pathFetchResponseSigningKey(&b),
 			pathRevoke(&b),
+			pathSign(&b),
+			pathTidy(&b),
+			pathVerify(&b),
 		},
 
 		Secrets: []*framework.Secret{
@@ -55,15 +77,36 @@ func Backend() *framework.Backend {
 
 	b.crlLifetime = time.Hour * 72
 	b.revokeStorageLock = &sync.Mutex{}
+	b.clock = time.Now
 
-	return b.Backend
+	return &b
 }
 
 type backend struct {
 	*framework.Backend
 
-	crlLifetime       time.Duration
+	crlLifetime time.Duration
+
+	// Serializes every read-modify-write of the revoked/ entries and the
+	// stored CRL: revocation, CRL rotation, tidying, and configuring the
+	// CA. It must be held when calling revokeCert or buildCRL.
 	revokeStorageLock *sync.Mutex
+
+	// The parsed CA bundle, cached so that it is not decoded again for
+	// every request; reset whenever the CA is configured
+	caInfoLock sync.RWMutex
+	caInfo     *caInfoBundle
+
+	// Serializes reading and updating the CA escrow state, so that the
+	// CA private key is escrowed at most once
+	caEscrowLock sync.Mutex
+
+	// Serializes installing certificates for pending CSRs and tidying
+	// them, so that each generated key is handed out at most once
+	pendingCSRLock sync.Mutex
+
+	// clock returns the current time; it is replaced in tests
+	clock func() time.Time
 }
 
 const backendHelp = `
@@ -1,19 +1,27 @@
 package pki
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
 	"fmt"
 	"math/big"
 	"net"
+	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/hashicorp/vault/helper/certutil"
 	"github.com/hashicorp/vault/logical"
@@ -25,23 +33,184 @@ const (
 	serverUsage certUsage = 1 << iota
 	clientUsage
 	codeSigningUsage
+	emailProtectionUsage
+	timeStampingUsage
+	ocspSigningUsage
 )
 
+// The names accepted for required extended key usages in roles
+var extKeyUsageNames = map[string]certUsage{
+	"server_auth":      serverUsage,
+	"client_auth":      clientUsage,
+	"code_signing":     codeSigningUsage,
+	"email_protection": emailProtectionUsage,
+	"time_stamping":    timeStampingUsage,
+	"ocsp_signing":     ocspSigningUsage,
+}
+
+// The names accepted for key usages in roles, matched case-insensitively
+var keyUsageNames = map[string]x509.KeyUsage{
+	"digitalsignature":  x509.KeyUsageDigitalSignature,
+	"contentcommitment": x509.KeyUsageContentCommitment,
+	"keyencipherment":   x509.KeyUsageKeyEncipherment,
+	"dataencipherment":  x509.KeyUsageDataEncipherment,
+	"keyagreement":      x509.KeyUsageKeyAgreement,
+	"certsign":          x509.KeyUsageCertSign,
+	"crlsign":           x509.KeyUsageCRLSign,
+	"encipheronly":      x509.KeyUsageEncipherOnly,
+	"decipheronly":      x509.KeyUsageDecipherOnly,
+}
+
+// The Certificate Transparency precertificate poison extension, from
+// RFC 6962 section 3.1
+var ctPoisonOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+
+// The embedded signed certificate timestamp list extension, from RFC 6962
+// section 3.3
+var ctSCTListOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// The legacy Netscape Comment extension
+var netscapeCommentOID = asn1.ObjectIdentifier{2, 16, 840, 1, 113730, 1, 13}
+
+// Matches DNS names made of valid labels, without a wildcard
+var hostnameRegex = regexp.MustCompile(`^(([a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9\-]*[a-zA-Z0-9])\.)*([A-Za-z0-9]|[A-Za-z0-9][A-Za-z0-9\-]*[A-Za-z0-9])$`)
+
+// The Subject Alternative Name extension, from RFC 5280 section 4.2.1.6
+var subjectAltNameOID = asn1.ObjectIdentifier{2, 5, 29, 17}
+
 type certCreationBundle struct {
-	SigningBundle *certutil.ParsedCertBundle
-	CACert        *x509.Certificate
-	CommonNames   []string
-	IPSANs        []net.IP
-	KeyType       string
-	KeyBits       int
-	TTL           time.Duration
-	Usage         certUsage
-}
-
-// Fetches the CA info. Unlike other certificates, the CA info is stored
-// in the backend as a CertBundle, because we are storing its private key
-func fetchCAInfo(req *logical.Request) (*certutil.ParsedCertBundle, error) {
-	bundleEntry, err := req.Storage.Get("config/ca_bundle")
+	SigningBundle      *certutil.ParsedCertBundle
+	CACert             *x509.Certificate
+	CommonNames        []string
+	IPSANs             []net.IP
+	URISANs            []*url.URL
+	Organization       string
+	OrganizationalUnit string
+	Country            []string
+	Locality           []string
+	Province           []string
+	PostalCode         []string
+	KeyType            string
+	KeyBits            int
+	TTL                time.Duration
+	NotBeforeDuration  time.Duration
+	Usage              certUsage
+	Precertificate     bool
+
+	// If set, the certificate is issued against this key instead of a
+	// newly generated one, and the key is not included in the result
+	SharedKey *certutil.ParsedCertBundle
+
+	// If set, the certificate is issued for the public key of this
+	// request, and the result holds no private key
+	CSR *x509.CertificateRequest
+
+	// If set, overrides the default key usages
+	KeyUsage x509.KeyUsage
+
+	// If set, added in the certificate policies extension
+	PolicyIdentifiers []asn1.ObjectIdentifier
+
+	// The key identifier of the CA, set as the authority key identifier
+	// unless OmitAuthorityKeyID is set
+	AuthorityKeyID []byte
+
+	// If set, the authority key identifier extension is left out
+	OmitAuthorityKeyID bool
+
+	// If set, added in the Netscape Comment extension
+	Comment string
+
+	// The size of the hash used for the signature; 256 if unset
+	SignatureBits int
+
+	// If set, subject attributes must respect their X.520 upper bounds
+	EnforceSubjectLengths bool
+
+	// If set, the configured issuing certificate, CRL distribution point,
+	// and OCSP server URLs
+	URLs *urlEntries
+
+	// The number of random bits in the serial number; 159 if unset
+	SerialBits int
+
+	// If set, the serial number always uses all of its bits, and so
+	// always encodes to the same length
+	FixedLengthSerial bool
+
+	// If set, the serialNumber attribute of the subject; otherwise it
+	// holds the certificate's serial number
+	SubjectSerialNumber string
+
+	// If set, the subject has no common name and CommonNames holds only
+	// the DNS SANs
+	OmitCommonName bool
+
+	// If set, the common name is not repeated in the DNS SANs
+	ExcludeCNFromSANs bool
+
+	// The time the validity period starts from, before any backdating;
+	// the current time if unset
+	Now time.Time
+
+	// otherName SANs, which need the SAN extension to be built here
+	// rather than by crypto/x509
+	OtherSANs []otherSAN
+}
+
+// caInfoBundle is the CA certificate and key, along with any certificates
+// above its issuer in the configured chain
+type caInfoBundle struct {
+	certutil.ParsedCertBundle
+
+	// The rest of the chain, in order, after the issuing CA
+	Chain []*x509.Certificate
+
+	// The subject key identifier of the CA certificate or, if it has
+	// none, one computed from its public key the way certutil does, so
+	// that it is the same across re-imports of the CA
+	SubjectKeyID []byte
+}
+
+// Fetches the CA info, from the cache if it has already been parsed. The
+// returned bundle is shared and must not be modified.
+func (b *backend) fetchCAInfo(req *logical.Request) (*caInfoBundle, error) {
+	b.caInfoLock.RLock()
+	cached := b.caInfo
+	b.caInfoLock.RUnlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	// Loading under the write lock orders it with invalidateCAInfo, so a
+	// bundle read before the CA was replaced is never cached afterwards
+	b.caInfoLock.Lock()
+	defer b.caInfoLock.Unlock()
+	if b.caInfo != nil {
+		return b.caInfo, nil
+	}
+
+	caInfo, err := loadCAInfo(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	b.caInfo = caInfo
+
+	return caInfo, nil
+}
+
+// Drops the cached CA info; called after the CA bundle is written
+func (b *backend) invalidateCAInfo() {
+	b.caInfoLock.Lock()
+	defer b.caInfoLock.Unlock()
+	b.caInfo = nil
+}
+
+// Reads and parses the CA info. Unlike other certificates, the CA info is
+// stored in the backend as a CertBundle, because we are storing its
+// private key. The rest of the chain, if any, is stored separately.
+func loadCAInfo(s logical.Storage) (*caInfoBundle, error) {
+	bundleEntry, err := s.Get("config/ca_bundle")
 	if err != nil {
 		return nil, certutil.InternalError{Err: fmt.Sprintf("Unable to fetch local CA certificate/key: %s", err)}
 	}
@@ -63,7 +232,160 @@ func fetchCAInfo(req *logical.Request) (*certutil.ParsedCertBundle, error) {
 		return nil, certutil.InternalError{Err: "Stored CA information not able to be parsed"}
 	}
 
-	return parsedBundle, nil
+	caInfo := &caInfoBundle{
+		ParsedCertBundle: *parsedBundle,
+		SubjectKeyID:     parsedBundle.Certificate.SubjectKeyId,
+	}
+	if len(caInfo.SubjectKeyID) == 0 {
+		caInfo.SubjectKeyID, err = certutil.GetSubjKeyIDFromPublicKey(parsedBundle.Certificate.PublicKey)
+		if err != nil {
+			return nil, certutil.InternalError{Err: fmt.Sprintf("Unable to compute the CA key identifier: %s", err)}
+		}
+	}
+
+	chainEntry, err := s.Get("config/ca_chain")
+	if err != nil {
+		return nil, certutil.InternalError{Err: fmt.Sprintf("Unable to fetch local CA chain: %s", err)}
+	}
+	if chainEntry != nil {
+		rest := chainEntry.Value
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, certutil.InternalError{Err: fmt.Sprintf("Unable to parse local CA chain: %s", err)}
+			}
+			caInfo.Chain = append(caInfo.Chain, cert)
+		}
+	}
+
+	return caInfo, nil
+}
+
+// Returns the PEM-encoded chain of the CA, starting with its own
+// certificate, followed by its issuer and the rest of the chain if those
+// were included in the configured bundle
+func caChain(signingBundle *caInfoBundle) ([]string, error) {
+	caBundle, err := signingBundle.ToCertBundle()
+	if err != nil {
+		return nil, certutil.InternalError{Err: fmt.Sprintf("Error converting CA bundle: %s", err)}
+	}
+
+	chain := []string{caBundle.Certificate}
+
+	// A self-signed CA is stored as its own issuer
+	if len(caBundle.IssuingCA) != 0 && caBundle.IssuingCA != caBundle.Certificate {
+		chain = append(chain, caBundle.IssuingCA)
+	}
+
+	for _, cert := range signingBundle.Chain {
+		chain = append(chain, string(pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: cert.Raw,
+		})))
+	}
+
+	return chain, nil
+}
+
+// Renders a serial number from its canonical colon-separated hex form into
+// the given format: "hex_colon" (the default), "hex", or "decimal"
+func formatSerial(serial, format string) (string, error) {
+	switch format {
+	case "", "hex_colon":
+		return serial, nil
+	case "hex":
+		return strings.Replace(serial, ":", "", -1), nil
+	case "decimal":
+		serialBytes, err := hex.DecodeString(strings.Replace(serial, ":", "", -1))
+		if err != nil {
+			return "", certutil.InternalError{Err: fmt.Sprintf("Unable to decode serial number %s: %s", serial, err)}
+		}
+		return new(big.Int).SetBytes(serialBytes).String(), nil
+	default:
+		return "", certutil.UserError{Err: fmt.Sprintf("Unknown serial format: %s", format)}
+	}
+}
+
+// Renders a private key as PEM in the given format: "pkcs1" (the default),
+// which keeps the key's native RSA or EC encoding, or "pkcs8"
+func formatPrivateKey(cb *certutil.CertBundle, key crypto.Signer, format string) error {
+	switch format {
+	case "", "pkcs1":
+		return nil
+	case "pkcs8":
+	default:
+		return certutil.UserError{Err: fmt.Sprintf("Unknown private key format: %s", format)}
+	}
+
+	if len(cb.PrivateKey) == 0 || key == nil {
+		return nil
+	}
+
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return certutil.InternalError{Err: fmt.Sprintf("Unable to marshal private key to PKCS#8: %s", err)}
+	}
+	cb.PrivateKey = strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})))
+
+	return nil
+}
+
+// Parses a serial number given in the given format into the canonical
+// lowercase colon-separated hex form used for storage. If no format is
+// given, colon- or hyphen-separated hex and plain hex are accepted;
+// decimal serial numbers must be requested explicitly.
+func parseSerial(serial, format string) (string, error) {
+	var serialBytes []byte
+	var err error
+
+	switch format {
+	case "":
+		if strings.ContainsAny(serial, ":-") {
+			return parseSerial(serial, "hex_colon")
+		}
+		return parseSerial(serial, "hex")
+	case "hex_colon":
+		serialBytes, err = hex.DecodeString(strings.NewReplacer(":", "", "-", "").Replace(serial))
+		if err == nil {
+			for _, octet := range strings.FieldsFunc(serial, func(r rune) bool { return r == ':' || r == '-' }) {
+				if len(octet) != 2 {
+					err = fmt.Errorf("octet %s is not two hex digits", octet)
+					break
+				}
+			}
+		}
+	case "hex":
+		if len(serial)%2 != 0 {
+			serial = "0" + serial
+		}
+		serialBytes, err = hex.DecodeString(serial)
+	case "decimal":
+		serialInt, ok := new(big.Int).SetString(serial, 10)
+		if !ok || serialInt.Sign() < 0 {
+			err = fmt.Errorf("not a non-negative decimal integer")
+		} else {
+			serialBytes = serialInt.Bytes()
+		}
+	default:
+		return "", certutil.UserError{Err: fmt.Sprintf("Unknown serial format: %s", format)}
+	}
+
+	if err != nil {
+		return "", certutil.UserError{Err: fmt.Sprintf("Invalid serial number %s: %s", serial, err)}
+	}
+
+	// Stored serials come from big.Int.Bytes(), which drops leading zeroes
+	serialBytes = new(big.Int).SetBytes(serialBytes).Bytes()
+	if len(serialBytes) == 0 {
+		return "", certutil.UserError{Err: fmt.Sprintf("Invalid serial number %s", serial)}
+	}
+
+	return certutil.GetOctalFormatted(serialBytes, ":"), nil
 }
 
 // Allows fetching certificates from the backend; it handles the slightly
@@ -94,14 +416,42 @@ func fetchCertBySerial(req *logical.Request, prefix, serial string) (*logical.St
 	return certEntry, nil
 }
 
+// Checks whether the name falls under any of the role's allowed domains.
+// Glob patterns must match the whole name, so subdomain settings do not
+// apply to them.
+func allowedByDomains(role *roleEntry, name, sanitizedName string, isWildcard bool, subdomainRegex *regexp.Regexp) bool {
+	for _, domain := range role.AllowedDomains {
+		if role.AllowGlobDomains && strings.Contains(domain, "*") {
+			if globMatch(domain, name) {
+				return true
+			}
+			continue
+		}
+
+		if !strings.HasSuffix(name, "."+domain) {
+			continue
+		}
+
+		if role.AllowSubdomains {
+			return true
+		}
+
+		if subdomainRegex.MatchString(strings.TrimSuffix(name, "."+domain)) {
+			return true
+		}
+
+		if isWildcard && domain == sanitizedName {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Given a set of requested names for a certificate, verifies that all of them
 // match the various toggles set in the role for controlling issuance.
 // If one does not pass, it is returned in the string argument.
 func validateCommonNames(req *logical.Request, commonNames []string, role *roleEntry) (string, error) {
-	hostnameRegex, err := regexp.Compile(`^(([a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9\-]*[a-zA-Z0-9])\.)*([A-Za-z0-9]|[A-Za-z0-9][A-Za-z0-9\-]*[A-Za-z0-9])$`)
-	if err != nil {
-		return "", fmt.Errorf("Error compiling hostname regex: %s", err)
-	}
 	subdomainRegex, err := regexp.Compile(`^(([a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9\-]*[a-zA-Z0-9]))*$`)
 	if err != nil {
 		return "", fmt.Errorf("Error compiling subdomain regex: %s", err)
@@ -114,14 +464,24 @@ func validateCommonNames(req *logical.Request, commonNames []string, role *roleE
 		sanitizedName := name
 		isWildcard := false
 		if strings.HasPrefix(name, "*.") {
+			if !role.AllowWildcardCertificates {
+				return name, nil
+			}
 			sanitizedName = name[2:]
 			isWildcard = true
 		}
 
+		// Only a single leading wildcard label is stripped, so a second
+		// wildcard or an invalid label after it fails here
 		if role.EnforceHostnames {
 			if !hostnameRegex.MatchString(sanitizedName) {
 				return name, nil
 			}
+			for _, label := range strings.Split(sanitizedName, ".") {
+				if len(label) > 63 {
+					return name, nil
+				}
+			}
 		}
 
 		if role.AllowAnyName {
@@ -140,20 +500,8 @@ func validateCommonNames(req *logical.Request, commonNames []string, role *roleE
 			}
 		}
 
-		if len(role.AllowedBaseDomain) != 0 {
-			if strings.HasSuffix(name, "."+role.AllowedBaseDomain) {
-				if role.AllowSubdomains {
-					continue
-				}
-
-				if subdomainRegex.MatchString(strings.TrimSuffix(name, "."+role.AllowedBaseDomain)) {
-					continue
-				}
-
-				if isWildcard && role.AllowedBaseDomain == sanitizedName {
-					continue
-				}
-			}
+		if allowedByDomains(role, name, sanitizedName, isWildcard, subdomainRegex) {
+			continue
 		}
 
 		return name, nil
@@ -162,113 +510,1242 @@ func validateCommonNames(req *logical.Request, commonNames []string, role *roleE
 	return "", nil
 }
 
-// Performs the heavy lifting of creating a certificate. Returns
-// a fully-filled-in ParsedCertBundle.
-func createCertificate(creationInfo *certCreationBundle) (*certutil.ParsedCertBundle, error) {
-	var clientPrivKey crypto.Signer
-	var err error
-	result := &certutil.ParsedCertBundle{}
+// Checks the common name against the role's allowed domains alone, for
+// roles that require it. The options that would otherwise let any name or
+// the token's display name through are ignored.
+func validateCNInAllowedDomains(req *logical.Request, cn string, role *roleEntry) (bool, error) {
+	domainsOnly := *role
+	domainsOnly.AllowLocalhost = false
+	domainsOnly.AllowAnyName = false
+	domainsOnly.AllowTokenDisplayName = false
 
-	var serialNumber *big.Int
-	serialNumber, err = rand.Int(rand.Reader, (&big.Int{}).Exp(big.NewInt(2), big.NewInt(159), nil))
+	badName, err := validateCommonNames(req, []string{cn}, &domainsOnly)
 	if err != nil {
-		return nil, certutil.InternalError{Err: fmt.Sprintf("Error getting random serial number")}
+		return false, err
 	}
+	return len(badName) == 0, nil
+}
 
-	switch creationInfo.KeyType {
-	case "rsa":
-		result.PrivateKeyType = certutil.RSAPrivateKey
-		clientPrivKey, err = rsa.GenerateKey(rand.Reader, creationInfo.KeyBits)
+// Parses a comma-delimited list of extended key usage names into the
+// corresponding usage flags
+func parseExtKeyUsages(names string) (certUsage, error) {
+	var usage certUsage
+	for _, v := range strings.Split(names, ",") {
+		flag, ok := extKeyUsageNames[strings.ToLower(strings.TrimSpace(v))]
+		if !ok {
+			return 0, certutil.UserError{Err: fmt.Sprintf("Unknown extended key usage: %s", v)}
+		}
+		usage = usage | flag
+	}
+	return usage, nil
+}
+
+// Parses a comma-delimited list of key usage names into the corresponding
+// bits
+func parseKeyUsages(names string) (x509.KeyUsage, error) {
+	var keyUsage x509.KeyUsage
+	for _, v := range strings.Split(names, ",") {
+		bit, ok := keyUsageNames[strings.ToLower(strings.TrimSpace(v))]
+		if !ok {
+			return 0, certutil.UserError{Err: fmt.Sprintf("Unknown key usage: %s", v)}
+		}
+		keyUsage = keyUsage | bit
+	}
+	return keyUsage, nil
+}
+
+// Parses a comma-delimited list of OIDs in dotted-decimal form, such as
+// "2.23.140.1.2.1"
+func parsePolicyIdentifiers(oids string) ([]asn1.ObjectIdentifier, error) {
+	var result []asn1.ObjectIdentifier
+	for _, v := range strings.Split(oids, ",") {
+		v = strings.TrimSpace(v)
+		oid, ok := parseOID(v)
+		if !ok {
+			return nil, certutil.UserError{Err: fmt.Sprintf("Invalid policy identifier: %s", v)}
+		}
+		result = append(result, oid)
+	}
+	return result, nil
+}
+
+// Parses a single OID in dotted-decimal form
+func parseOID(v string) (asn1.ObjectIdentifier, bool) {
+	arcs := strings.Split(v, ".")
+	if len(arcs) < 2 {
+		return nil, false
+	}
+
+	oid := make(asn1.ObjectIdentifier, len(arcs))
+	for i, arc := range arcs {
+		n, err := strconv.Atoi(arc)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		oid[i] = n
+	}
+
+	// The first two arcs are encoded together, which limits them
+	if oid[0] > 2 || (oid[0] < 2 && oid[1] >= 40) {
+		return nil, false
+	}
+
+	return oid, true
+}
+
+// Returns the extended key usages of certificates issued by the role
+func roleUsage(role *roleEntry) (certUsage, error) {
+	var usage certUsage
+	if role.ServerFlag {
+		usage = usage | serverUsage
+	}
+	if role.ClientFlag {
+		usage = usage | clientUsage
+	}
+	if role.CodeSigningFlag {
+		usage = usage | codeSigningUsage
+	}
+	if len(role.RequiredExtKeyUsage) != 0 {
+		requiredUsage, err := parseExtKeyUsages(role.RequiredExtKeyUsage)
 		if err != nil {
-			return nil, certutil.InternalError{Err: fmt.Sprintf("Error generating RSA private key")}
+			return 0, err
 		}
-		result.PrivateKey = clientPrivKey
-		result.PrivateKeyBytes = x509.MarshalPKCS1PrivateKey(clientPrivKey.(*rsa.PrivateKey))
-	case "ec":
-		result.PrivateKeyType = certutil.ECPrivateKey
-		var curve elliptic.Curve
-		switch creationInfo.KeyBits {
-		case 224:
-			curve = elliptic.P224()
-		case 256:
-			curve = elliptic.P256()
-		case 384:
-			curve = elliptic.P384()
-		case 521:
-			curve = elliptic.P521()
-		default:
-			return nil, certutil.UserError{Err: fmt.Sprintf("Unsupported bit length for EC key: %d", creationInfo.KeyBits)}
+		usage = usage | requiredUsage
+	}
+	return usage, nil
+}
+
+// Checks the attributes of the given subject against the upper bounds
+// from RFC 5280 appendix A
+func validateSubjectLengths(subject pkix.Name) error {
+	attributes := []struct {
+		name   string
+		values []string
+		bound  int
+	}{
+		{"common name", []string{subject.CommonName}, 64},
+		{"serial number", []string{subject.SerialNumber}, 64},
+		{"country", subject.Country, 2},
+		{"organization", subject.Organization, 64},
+		{"organizational unit", subject.OrganizationalUnit, 64},
+		{"locality", subject.Locality, 128},
+		{"province", subject.Province, 128},
+		{"postal code", subject.PostalCode, 40},
+	}
+
+	for _, attribute := range attributes {
+		for _, value := range attribute.values {
+			if length := utf8.RuneCountInString(value); length > attribute.bound {
+				return certutil.UserError{Err: fmt.Sprintf("The %s %q is %d characters long, but may be at most %d", attribute.name, value, length, attribute.bound)}
+			}
 		}
-		clientPrivKey, err = ecdsa.GenerateKey(curve, rand.Reader)
+	}
+
+	return nil
+}
+
+var issuanceDayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Parses an issuance window of the form "HH:MM-HH:MM", returning the offset
+// of its opening from midnight UTC and its length
+func parseIssuanceWindow(window string) (time.Duration, time.Duration, error) {
+	parts := strings.Split(window, "-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Invalid issuance window %q; expected HH:MM-HH:MM", window)
+	}
+
+	var bounds [2]time.Duration
+	for i, v := range parts {
+		parsed, err := time.Parse("15:04", strings.TrimSpace(v))
 		if err != nil {
-			return nil, certutil.InternalError{Err: fmt.Sprintf("Error generating EC private key")}
+			return 0, 0, fmt.Errorf("Invalid issuance window %q; expected HH:MM-HH:MM", window)
+		}
+		bounds[i] = time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute
+	}
+
+	// A window that ends before it starts crosses midnight
+	length := bounds[1] - bounds[0]
+	if length <= 0 {
+		length += 24 * time.Hour
+	}
+
+	return bounds[0], length, nil
+}
+
+// Parses a comma-delimited list of issuance days; a nil map means every day
+func parseIssuanceDays(days string) (map[time.Weekday]bool, error) {
+	if len(days) == 0 {
+		return nil, nil
+	}
+
+	ret := map[time.Weekday]bool{}
+	for _, v := range strings.Split(days, ",") {
+		day, ok := issuanceDayNames[strings.ToLower(strings.TrimSpace(v))]
+		if !ok {
+			return nil, fmt.Errorf("Unknown day in issuance_days: %s", v)
 		}
-		result.PrivateKey = clientPrivKey
-		result.PrivateKeyBytes, err = x509.MarshalECPrivateKey(clientPrivKey.(*ecdsa.PrivateKey))
+		ret[day] = true
+	}
+
+	return ret, nil
+}
+
+// Returns the earliest time, no earlier than now, at which the role allows
+// issuance; this is now itself if the issuance window is currently open
+func nextIssuanceTime(role *roleEntry, now time.Time) (time.Time, error) {
+	if len(role.IssuanceWindow) == 0 && len(role.IssuanceDays) == 0 {
+		return now, nil
+	}
+
+	opening, length := time.Duration(0), 24*time.Hour
+	if len(role.IssuanceWindow) != 0 {
+		var err error
+		opening, length, err = parseIssuanceWindow(role.IssuanceWindow)
 		if err != nil {
-			return nil, certutil.InternalError{Err: fmt.Sprintf("Error marshalling EC private key")}
+			return time.Time{}, err
+		}
+	}
+	days, err := parseIssuanceDays(role.IssuanceDays)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// Start from yesterday's window, which may still be open if it crosses
+	// midnight, and walk forward a week
+	utc := now.UTC()
+	midnight := time.Date(utc.Year(), utc.Month(), utc.Day(), 0, 0, 0, 0, time.UTC)
+	for d := -1; d <= 7; d++ {
+		opens := midnight.AddDate(0, 0, d).Add(opening)
+		if days != nil && !days[opens.Weekday()] {
+			continue
+		}
+		if now.Before(opens) {
+			return opens, nil
+		}
+		if now.Before(opens.Add(length)) {
+			return now, nil
 		}
+	}
+
+	return time.Time{}, fmt.Errorf("Unable to find the next issuance window")
+}
+
+// Returns the role key type ("rsa", "ec", or "ed25519") and size of the
+// given public key
+func publicKeyTypeBits(publicKey crypto.PublicKey) (string, int) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return "rsa", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ec", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "ed25519", 0
 	default:
-		return nil, certutil.UserError{Err: fmt.Sprintf("Unknown key type: %s", creationInfo.KeyType)}
+		return fmt.Sprintf("%T", publicKey), 0
 	}
+}
 
-	subjKeyID, err := certutil.GetSubjKeyID(result.PrivateKey)
-	if err != nil {
-		return nil, certutil.InternalError{Err: fmt.Sprintf("Error getting subject key ID: %s", err)}
+// Returns the names to request for a CSR: the requested ones where given,
+// and otherwise those in the CSR. DNS names repeating the common name are
+// left out, as the common name is always included.
+func csrNames(csr *x509.CertificateRequest, cn, cnAlt, ipAlt, uriAlt string) (string, string, string, string) {
+	if len(cn) == 0 {
+		cn = csr.Subject.CommonName
 	}
 
-	subject := pkix.Name{
-		Country:            creationInfo.CACert.Subject.Country,
-		Organization:       creationInfo.CACert.Subject.Organization,
-		OrganizationalUnit: creationInfo.CACert.Subject.OrganizationalUnit,
-		Locality:           creationInfo.CACert.Subject.Locality,
-		Province:           creationInfo.CACert.Subject.Province,
-		StreetAddress:      creationInfo.CACert.Subject.StreetAddress,
-		PostalCode:         creationInfo.CACert.Subject.PostalCode,
-		SerialNumber:       serialNumber.String(),
-		CommonName:         creationInfo.CommonNames[0],
+	if len(cnAlt) == 0 {
+		var names []string
+		for _, v := range csr.DNSNames {
+			if v != cn {
+				names = append(names, v)
+			}
+		}
+		cnAlt = strings.Join(names, ",")
 	}
 
-	certTemplate := &x509.Certificate{
-		SignatureAlgorithm:    x509.SHA256WithRSA,
-		SerialNumber:          serialNumber,
-		Subject:               subject,
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().Add(creationInfo.TTL),
-		KeyUsage:              x509.KeyUsage(x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement),
-		BasicConstraintsValid: true,
-		IsCA:                        false,
-		SubjectKeyId:                subjKeyID,
-		DNSNames:                    creationInfo.CommonNames,
-		IPAddresses:                 creationInfo.IPSANs,
-		PermittedDNSDomainsCritical: false,
-		PermittedDNSDomains:         nil,
-		CRLDistributionPoints:       creationInfo.CACert.CRLDistributionPoints,
+	if len(ipAlt) == 0 {
+		var ips []string
+		for _, v := range csr.IPAddresses {
+			ips = append(ips, v.String())
+		}
+		ipAlt = strings.Join(ips, ",")
 	}
 
-	if creationInfo.Usage&serverUsage != 0 {
-		certTemplate.ExtKeyUsage = append(certTemplate.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+	if len(uriAlt) == 0 {
+		var uris []string
+		for _, v := range csr.URIs {
+			uris = append(uris, v.String())
+		}
+		uriAlt = strings.Join(uris, ",")
 	}
-	if creationInfo.Usage&clientUsage != 0 {
-		certTemplate.ExtKeyUsage = append(certTemplate.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+
+	return cn, cnAlt, ipAlt, uriAlt
+}
+
+// Checks a key of the given type and size against an EC-only role
+func checkECOnlyKey(role *roleEntry, keyType string, keyBits int) error {
+	if keyType != "ec" {
+		return certutil.UserError{Err: fmt.Sprintf("Only EC keys are allowed by this role, but the key type is %s", keyType)}
 	}
-	if creationInfo.Usage&codeSigningUsage != 0 {
-		certTemplate.ExtKeyUsage = append(certTemplate.ExtKeyUsage, x509.ExtKeyUsageCodeSigning)
+	if len(role.AllowedECCurves) == 0 {
+		return nil
 	}
 
-	cert, err := x509.CreateCertificate(rand.Reader, certTemplate, creationInfo.CACert, clientPrivKey.Public(), creationInfo.SigningBundle.PrivateKey)
-	if err != nil {
-		return nil, certutil.InternalError{Err: fmt.Sprintf("Unable to create certificate: %s", err)}
+	curve := fmt.Sprintf("P-%d", keyBits)
+	for _, v := range strings.Split(role.AllowedECCurves, ",") {
+		if strings.TrimSpace(v) == curve {
+			return nil
+		}
 	}
+	return certutil.UserError{Err: fmt.Sprintf("Curve %s is not allowed by this role", curve)}
+}
 
-	result.CertificateBytes = cert
-	result.Certificate, err = x509.ParseCertificate(cert)
-	if err != nil {
-		return nil, certutil.InternalError{Err: fmt.Sprintf("Unable to parse created certificate: %s", err)}
+// Checks the key of a CSR or a shared key against the key types and
+// minimum sizes allowed by the role
+func checkCSRKey(role *roleEntry, keyType string, keyBits int) error {
+	if len(role.AllowedKeyTypes) != 0 {
+		allowed := false
+		for _, v := range strings.Split(role.AllowedKeyTypes, ",") {
+			if strings.TrimSpace(v) == keyType {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return certutil.UserError{Err: fmt.Sprintf("Key type %s is not allowed by this role", keyType)}
+		}
 	}
 
-	result.IssuingCABytes = creationInfo.SigningBundle.CertificateBytes
-	result.IssuingCA = creationInfo.SigningBundle.Certificate
+	switch {
+	case keyType == "rsa" && keyBits < role.MinRSAKeyBits:
+		return certutil.UserError{Err: fmt.Sprintf("RSA key of %d bits is smaller than the minimum of %d bits allowed by this role", keyBits, role.MinRSAKeyBits)}
+	case keyType == "ec" && keyBits < role.MinECKeyBits:
+		return certutil.UserError{Err: fmt.Sprintf("EC key of %d bits is smaller than the minimum of %d bits allowed by this role", keyBits, role.MinECKeyBits)}
+	}
 
-	return result, nil
+	return nil
+}
+
+// Splits a comma-delimited subject attribute or domain list of a role into
+// its values
+func subjectValues(field string) []string {
+	var values []string
+	for _, v := range strings.Split(field, ",") {
+		if v = strings.TrimSpace(v); len(v) != 0 {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// Checks whether the role allows the given organization to be requested
+func organizationAllowed(role *roleEntry, organization string) bool {
+	for _, v := range strings.Split(role.AllowedOrganizations, ",") {
+		if strings.TrimSpace(v) == organization {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks that the value is an ISO 3166-1 alpha-2 country code
+func validCountryCode(country string) bool {
+	if len(country) != 2 {
+		return false
+	}
+	for _, c := range country {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// Checks whether the role allows the given country to be used
+func countryAllowed(role *roleEntry, country string) bool {
+	for _, v := range subjectValues(role.AllowedCountries) {
+		if v == country {
+			return true
+		}
+	}
+	return false
+}
+
+// Ensures that the names include one ending in the role's mandatory SAN
+// suffix, appending one derived from the common name or rejecting the
+// request depending on the role
+func applyMandatorySANSuffix(role *roleEntry, commonNames []string) ([]string, error) {
+	if len(role.MandatorySANSuffix) == 0 {
+		return commonNames, nil
+	}
+
+	for _, name := range commonNames {
+		if strings.HasSuffix(name, "."+role.MandatorySANSuffix) {
+			return commonNames, nil
+		}
+	}
+
+	if role.MandatorySANAction == "reject" {
+		return nil, certutil.UserError{Err: fmt.Sprintf(
+			"This role requires a name ending in .%s", role.MandatorySANSuffix)}
+	}
+
+	// The only requested name may have been an IP address moved to the
+	// IP SANs
+	if len(commonNames) == 0 {
+		return nil, certutil.UserError{Err: fmt.Sprintf(
+			"Cannot derive a name ending in .%s without a DNS name", role.MandatorySANSuffix)}
+	}
+
+	label := strings.Split(commonNames[0], ".")[0]
+	if len(label) == 0 || label == "*" {
+		return nil, certutil.UserError{Err: fmt.Sprintf(
+			"Cannot derive a name ending in .%s from common name %s", role.MandatorySANSuffix, commonNames[0])}
+	}
+
+	return append(commonNames, label+"."+role.MandatorySANSuffix), nil
+}
+
+// Matches a value against a pattern in which "*" matches any sequence of
+// characters, including none
+func globMatch(pattern, value string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == value
+	}
+
+	if !strings.HasPrefix(value, parts[0]) {
+		return false
+	}
+	value = value[len(parts[0]):]
+
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(value, part)
+		if i < 0 {
+			return false
+		}
+		value = value[i+len(part):]
+	}
+
+	return len(value) >= len(last) && strings.HasSuffix(value, last)
+}
+
+// Parses the comma-delimited IP SANs of a request and checks them against
+// the role
+func parseIPSANs(role *roleEntry, ipAlt string) ([]net.IP, error) {
+	ipSANs := []net.IP{}
+	if len(ipAlt) == 0 {
+		return ipSANs, nil
+	}
+
+	if !role.AllowIPSANs {
+		return nil, certutil.UserError{Err: fmt.Sprintf(
+			"IP Subject Alternative Names are not allowed in this role, but was provided %s", ipAlt)}
+	}
+
+	for _, v := range strings.Split(ipAlt, ",") {
+		parsedIP := net.ParseIP(v)
+		if parsedIP == nil {
+			return nil, certutil.UserError{Err: fmt.Sprintf("The value '%s' is not a valid IP address", v)}
+		}
+		if !ipAddressAllowed(role, parsedIP) {
+			return nil, certutil.UserError{Err: fmt.Sprintf("IP address %s not allowed by this role", v)}
+		}
+		ipSANs = append(ipSANs, parsedIP)
+	}
+
+	return ipSANs, nil
+}
+
+// Checks whether the IP address is in the role's list of allowed addresses
+// or within one of its allowed CIDR blocks; any address is allowed if
+// neither is set. Addresses are compared by value, so different spellings
+// of the same IPv6 address match.
+func ipAddressAllowed(role *roleEntry, ip net.IP) bool {
+	if len(role.AllowedIPAddresses) == 0 && len(role.AllowedIPSANs) == 0 {
+		return true
+	}
+
+	if len(role.AllowedIPAddresses) != 0 {
+		for _, v := range strings.Split(role.AllowedIPAddresses, ",") {
+			if ip.Equal(net.ParseIP(strings.TrimSpace(v))) {
+				return true
+			}
+		}
+	}
+
+	if len(role.AllowedIPSANs) != 0 {
+		for _, v := range strings.Split(role.AllowedIPSANs, ",") {
+			_, ipNet, err := net.ParseCIDR(strings.TrimSpace(v))
+			if err == nil && ipNet.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Applies the configured handling to a common name that is an IP literal,
+// which many TLS clients refuse to match. commonNames[0] must be the
+// requested common name. Returns the updated names and IP SANs, along
+// with a warning for the response, if any.
+func handleIPCommonName(mode string, role *roleEntry, commonNames []string, ipSANs []net.IP) ([]string, []net.IP, string, error) {
+	parsedIP := net.ParseIP(commonNames[0])
+	if parsedIP == nil {
+		return commonNames, ipSANs, "", nil
+	}
+
+	switch mode {
+	case "reject":
+		return nil, nil, "", certutil.UserError{Err: fmt.Sprintf(
+			"The common name %s is an IP address; request it in ip_sans instead", commonNames[0])}
+
+	case "move":
+		if !role.AllowIPSANs {
+			return nil, nil, "", certutil.UserError{Err: fmt.Sprintf(
+				"The common name %s is an IP address, but IP Subject Alternative Names are not allowed in this role", commonNames[0])}
+		}
+		if !ipAddressAllowed(role, parsedIP) {
+			return nil, nil, "", certutil.UserError{Err: fmt.Sprintf("IP address %s not allowed by this role", commonNames[0])}
+		}
+		for _, v := range ipSANs {
+			if v.Equal(parsedIP) {
+				return commonNames[1:], ipSANs, "", nil
+			}
+		}
+		return commonNames[1:], append(ipSANs, parsedIP), "", nil
+
+	default:
+		return commonNames, ipSANs, fmt.Sprintf(
+			"The common name %s is an IP address; many TLS clients only match IP addresses against IP Subject Alternative Names", commonNames[0]), nil
+	}
+}
+
+// Parses the comma-delimited URI SANs of a request and checks them
+// against the role
+func parseURISANs(role *roleEntry, uriAlt string) ([]*url.URL, error) {
+	var uriSANs []*url.URL
+	if len(uriAlt) == 0 {
+		return uriSANs, nil
+	}
+
+	if !role.AllowURISANs {
+		return nil, certutil.UserError{Err: fmt.Sprintf(
+			"URI Subject Alternative Names are not allowed in this role, but was provided %s", uriAlt)}
+	}
+
+	for _, v := range strings.Split(uriAlt, ",") {
+		v = strings.TrimSpace(v)
+		parsedURI, err := url.Parse(v)
+		if err != nil || !parsedURI.IsAbs() {
+			return nil, certutil.UserError{Err: fmt.Sprintf("The value '%s' is not a valid absolute URI", v)}
+		}
+
+		if len(role.AllowedURISANs) != 0 {
+			allowed := false
+			for _, pattern := range strings.Split(role.AllowedURISANs, ",") {
+				if globMatch(strings.TrimSpace(pattern), v) {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				return nil, certutil.UserError{Err: fmt.Sprintf("URI %s not allowed by this role", v)}
+			}
+		}
+
+		uriSANs = append(uriSANs, parsedURI)
+	}
+
+	return uriSANs, nil
+}
+
+// otherSAN is an otherName Subject Alternative Name with a UTF-8 string
+// value, such as the Microsoft user principal name used for smartcard
+// logon (1.3.6.1.4.1.311.20.2.3)
+type otherSAN struct {
+	OID   asn1.ObjectIdentifier
+	Value string
+}
+
+// Parses an other SAN of the form "oid;UTF8:value"
+func parseOtherSAN(v string) (otherSAN, error) {
+	split := strings.SplitN(v, ";", 2)
+	if len(split) != 2 || !strings.HasPrefix(split[1], "UTF8:") {
+		return otherSAN{}, certutil.UserError{Err: fmt.Sprintf("The value '%s' is not of the form oid;UTF8:value", v)}
+	}
+
+	oid, ok := parseOID(split[0])
+	if !ok {
+		return otherSAN{}, certutil.UserError{Err: fmt.Sprintf("Invalid OID in other SAN '%s'", v)}
+	}
+
+	return otherSAN{
+		OID:   oid,
+		Value: strings.TrimPrefix(split[1], "UTF8:"),
+	}, nil
+}
+
+// Parses the comma-delimited other SANs of a request and checks them
+// against the role
+func parseOtherSANs(role *roleEntry, otherAlt string) ([]otherSAN, error) {
+	var otherSANs []otherSAN
+	if len(otherAlt) == 0 {
+		return otherSANs, nil
+	}
+
+	if len(role.AllowedOtherSANs) == 0 {
+		return nil, certutil.UserError{Err: fmt.Sprintf(
+			"Other Subject Alternative Names are not allowed in this role, but was provided %s", otherAlt)}
+	}
+
+	for _, v := range strings.Split(otherAlt, ",") {
+		v = strings.TrimSpace(v)
+		san, err := parseOtherSAN(v)
+		if err != nil {
+			return nil, err
+		}
+		if !otherSANAllowed(role, san) {
+			return nil, certutil.UserError{Err: fmt.Sprintf("Other SAN %s not allowed by this role", v)}
+		}
+		otherSANs = append(otherSANs, san)
+	}
+
+	return otherSANs, nil
+}
+
+// Returns whether the role allows the given other SAN. Each entry of
+// allowed_other_sans is either "*", allowing any, or of the form
+// "oid;UTF8:pattern", in which "*" matches any sequence of characters.
+func otherSANAllowed(role *roleEntry, san otherSAN) bool {
+	for _, v := range strings.Split(role.AllowedOtherSANs, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" {
+			return true
+		}
+		allowed, err := parseOtherSAN(v)
+		if err != nil {
+			continue
+		}
+		if allowed.OID.Equal(san.OID) && globMatch(allowed.Value, san.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the DER-encoded Subject Alternative Name extension value for
+// the given names, encoded the same way as crypto/x509 does. Other names
+// are added after the rest, since crypto/x509 cannot encode them.
+func marshalSANs(dnsNames []string, ipSANs []net.IP, uriSANs []*url.URL, otherSANs []otherSAN) ([]byte, error) {
+	var rawValues []asn1.RawValue
+	for _, name := range dnsNames {
+		rawValues = append(rawValues, asn1.RawValue{Tag: 2, Class: asn1.ClassContextSpecific, Bytes: []byte(name)})
+	}
+	for _, ip := range ipSANs {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		rawValues = append(rawValues, asn1.RawValue{Tag: 7, Class: asn1.ClassContextSpecific, Bytes: ip})
+	}
+	for _, uri := range uriSANs {
+		rawValues = append(rawValues, asn1.RawValue{Tag: 6, Class: asn1.ClassContextSpecific, Bytes: []byte(uri.String())})
+	}
+	for _, san := range otherSANs {
+		value, err := asn1.MarshalWithParams(san.Value, "utf8")
+		if err != nil {
+			return nil, err
+		}
+		// OtherName ::= SEQUENCE { type-id OID, value [0] EXPLICIT ANY },
+		// itself implicitly tagged [0] in the GeneralName choice
+		otherName, err := asn1.MarshalWithParams(struct {
+			TypeID asn1.ObjectIdentifier
+			Value  asn1.RawValue
+		}{
+			TypeID: san.OID,
+			Value:  asn1.RawValue{Tag: 0, Class: asn1.ClassContextSpecific, IsCompound: true, Bytes: value},
+		}, "tag:0")
+		if err != nil {
+			return nil, err
+		}
+		rawValues = append(rawValues, asn1.RawValue{FullBytes: otherName})
+	}
+
+	return asn1.Marshal(rawValues)
+}
+
+// Checks the final Subject Alternative Names of a certificate against the
+// role's length limits
+func checkSANLengths(role *roleEntry, dnsNames []string, ipSANs []net.IP, uriSANs []*url.URL, otherSANs []otherSAN) error {
+	if role.MaxDNSNameLength > 0 {
+		for _, name := range dnsNames {
+			if len(name) > role.MaxDNSNameLength {
+				return certutil.UserError{Err: fmt.Sprintf(
+					"DNS name %s is %d characters long, exceeding the limit of %d for this role",
+					name, len(name), role.MaxDNSNameLength)}
+			}
+		}
+	}
+
+	if role.MaxSANLength > 0 {
+		encoded, err := marshalSANs(dnsNames, ipSANs, uriSANs, otherSANs)
+		if err != nil {
+			return certutil.UserError{Err: fmt.Sprintf("Unable to encode Subject Alternative Names: %s", err)}
+		}
+		if len(encoded) > role.MaxSANLength {
+			return certutil.UserError{Err: fmt.Sprintf(
+				"Subject Alternative Names are %d bytes long when encoded, exceeding the limit of %d for this role",
+				len(encoded), role.MaxSANLength)}
+		}
+	}
+
+	return nil
+}
+
+// Checks a default SAN of a role, which must be an IP address, an
+// absolute URI, or a DNS name, optionally with a leading wildcard label
+func validateDefaultSAN(v string) error {
+	if net.ParseIP(v) != nil {
+		return nil
+	}
+
+	// DNS names cannot contain colons
+	if strings.Contains(v, ":") {
+		parsedURI, err := url.Parse(v)
+		if err != nil || !parsedURI.IsAbs() {
+			return fmt.Errorf("The value '%s' in default_sans is not a valid absolute URI", v)
+		}
+		return nil
+	}
+
+	if !hostnameRegex.MatchString(strings.TrimPrefix(v, "*.")) {
+		return fmt.Errorf("The value '%s' in default_sans is not a valid hostname", v)
+	}
+
+	return nil
+}
+
+// Adds the comma-delimited default SANs of a role to the given DNS names,
+// IP addresses, and URIs, skipping any that were already requested
+func mergeDefaultSANs(defaultSANs string, commonNames []string, ipSANs []net.IP, uriSANs []*url.URL) ([]string, []net.IP, []*url.URL, error) {
+DEFAULTS:
+	for _, v := range strings.Split(defaultSANs, ",") {
+		v = strings.TrimSpace(v)
+		if err := validateDefaultSAN(v); err != nil {
+			return nil, nil, nil, certutil.UserError{Err: err.Error()}
+		}
+
+		if parsedIP := net.ParseIP(v); parsedIP != nil {
+			for _, ip := range ipSANs {
+				if ip.Equal(parsedIP) {
+					continue DEFAULTS
+				}
+			}
+			ipSANs = append(ipSANs, parsedIP)
+			continue
+		}
+
+		if strings.Contains(v, ":") {
+			parsedURI, _ := url.Parse(v)
+			for _, uri := range uriSANs {
+				if uri.String() == parsedURI.String() {
+					continue DEFAULTS
+				}
+			}
+			uriSANs = append(uriSANs, parsedURI)
+			continue
+		}
+
+		for _, name := range commonNames {
+			if strings.EqualFold(name, v) {
+				continue DEFAULTS
+			}
+		}
+		commonNames = append(commonNames, v)
+	}
+
+	return commonNames, ipSANs, uriSANs, nil
+}
+
+// The RSA key sizes that roles can be created with. 8192-bit keys are
+// accepted for long-lived keys such as intermediate CAs, though they are
+// slow to generate. Roles stored with other sizes before the limit existed
+// keep generating keys of their size.
+var rsaKeyBits = []int{2048, 3072, 4096, 8192}
+
+// Returns the curve for an EC key of the given size, or nil if there is
+// none
+func ecCurve(keyBits int) elliptic.Curve {
+	switch keyBits {
+	case 224:
+		return elliptic.P224()
+	case 256:
+		return elliptic.P256()
+	case 384:
+		return elliptic.P384()
+	case 521:
+		return elliptic.P521()
+	default:
+		return nil
+	}
+}
+
+// Checks that a role may be created for keys of the given type and size.
+// The size is ignored for Ed25519 keys.
+func validateKeyTypeBits(keyType string, keyBits int) error {
+	switch keyType {
+	case "rsa":
+		for _, v := range rsaKeyBits {
+			if keyBits == v {
+				return nil
+			}
+		}
+		return certutil.UserError{Err: fmt.Sprintf("Unsupported bit length for RSA key: %d", keyBits)}
+	case "ec":
+		if ecCurve(keyBits) == nil {
+			return certutil.UserError{Err: fmt.Sprintf("Unsupported bit length for EC key: %d", keyBits)}
+		}
+		return nil
+	case "ed25519":
+		return nil
+	default:
+		return certutil.UserError{Err: fmt.Sprintf("Unknown key type %s", keyType)}
+	}
+}
+
+// Generates a private key of the given type and size into the bundle
+func generatePrivateKey(keyType string, keyBits int, result *certutil.ParsedCertBundle) error {
+	switch keyType {
+	case "rsa":
+		result.PrivateKeyType = certutil.RSAPrivateKey
+		privKey, err := rsa.GenerateKey(rand.Reader, keyBits)
+		if err != nil {
+			return certutil.InternalError{Err: fmt.Sprintf("Error generating RSA private key")}
+		}
+		result.PrivateKey = privKey
+		result.PrivateKeyBytes = x509.MarshalPKCS1PrivateKey(privKey)
+	case "ec":
+		result.PrivateKeyType = certutil.ECPrivateKey
+		curve := ecCurve(keyBits)
+		if curve == nil {
+			return certutil.UserError{Err: fmt.Sprintf("Unsupported bit length for EC key: %d", keyBits)}
+		}
+		privKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return certutil.InternalError{Err: fmt.Sprintf("Error generating EC private key")}
+		}
+		result.PrivateKey = privKey
+		result.PrivateKeyBytes, err = x509.MarshalECPrivateKey(privKey)
+		if err != nil {
+			return certutil.InternalError{Err: fmt.Sprintf("Error marshalling EC private key")}
+		}
+	case "ed25519":
+		result.PrivateKeyType = certutil.Ed25519PrivateKey
+		_, privKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return certutil.InternalError{Err: fmt.Sprintf("Error generating Ed25519 private key")}
+		}
+		result.PrivateKey = privKey
+		result.PrivateKeyBytes, err = x509.MarshalPKCS8PrivateKey(privKey)
+		if err != nil {
+			return certutil.InternalError{Err: fmt.Sprintf("Error marshalling Ed25519 private key")}
+		}
+	default:
+		return certutil.UserError{Err: fmt.Sprintf("Unknown key type: %s", keyType)}
+	}
+	return nil
+}
+
+// Returns the signature algorithm for the signing key with a hash of the
+// given size, or an error if the key cannot be used with such a hash
+func signatureAlgorithm(signingKey crypto.Signer, signatureBits int) (x509.SignatureAlgorithm, error) {
+	switch signingKey.(type) {
+	case *rsa.PrivateKey:
+		switch signatureBits {
+		case 0, 256:
+			return x509.SHA256WithRSA, nil
+		case 384:
+			return x509.SHA384WithRSA, nil
+		case 512:
+			return x509.SHA512WithRSA, nil
+		}
+	case *ecdsa.PrivateKey:
+		switch signatureBits {
+		case 0, 256:
+			return x509.ECDSAWithSHA256, nil
+		case 384:
+			return x509.ECDSAWithSHA384, nil
+		case 512:
+			return x509.ECDSAWithSHA512, nil
+		}
+	default:
+		return x509.UnknownSignatureAlgorithm, certutil.UserError{Err: fmt.Sprintf("Unsupported signing key type %T", signingKey)}
+	}
+
+	return x509.UnknownSignatureAlgorithm, certutil.UserError{Err: fmt.Sprintf("Unsupported signature hash size: %d", signatureBits)}
+}
+
+// Performs the heavy lifting of creating a certificate. Returns
+// a fully-filled-in ParsedCertBundle.
+func createCertificate(creationInfo *certCreationBundle) (*certutil.ParsedCertBundle, error) {
+	var clientPubKey crypto.PublicKey
+	var err error
+	result := &certutil.ParsedCertBundle{}
+
+	serialBits := creationInfo.SerialBits
+	if serialBits == 0 {
+		serialBits = defaultSerialBits
+	}
+
+	// Serial numbers must be positive, so zero is drawn again
+	serialNumber := new(big.Int)
+	for serialNumber.Sign() == 0 {
+		serialNumber, err = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), uint(serialBits)))
+		if err != nil {
+			return nil, certutil.InternalError{Err: fmt.Sprintf("Error getting random serial number")}
+		}
+	}
+	if creationInfo.FixedLengthSerial {
+		// Setting the top bit of the range keeps the serial positive
+		// while always needing the same number of octets
+		serialNumber.SetBit(serialNumber, serialBits-1, 1)
+	}
+
+	switch {
+	case creationInfo.CSR != nil:
+		clientPubKey = creationInfo.CSR.PublicKey
+	case creationInfo.SharedKey != nil:
+		result.PrivateKeyType = creationInfo.SharedKey.PrivateKeyType
+		result.PrivateKey = creationInfo.SharedKey.PrivateKey
+		clientPubKey = result.PrivateKey.Public()
+	default:
+		if err := generatePrivateKey(creationInfo.KeyType, creationInfo.KeyBits, result); err != nil {
+			return nil, err
+		}
+		clientPubKey = result.PrivateKey.Public()
+	}
+
+	subjKeyID, err := certutil.GetSubjKeyIDFromPublicKey(clientPubKey)
+	if err != nil {
+		return nil, certutil.InternalError{Err: fmt.Sprintf("Error getting subject key ID: %s", err)}
+	}
+
+	subject := pkix.Name{
+		Country:            creationInfo.CACert.Subject.Country,
+		Organization:       creationInfo.CACert.Subject.Organization,
+		OrganizationalUnit: creationInfo.CACert.Subject.OrganizationalUnit,
+		Locality:           creationInfo.CACert.Subject.Locality,
+		Province:           creationInfo.CACert.Subject.Province,
+		StreetAddress:      creationInfo.CACert.Subject.StreetAddress,
+		PostalCode:         creationInfo.CACert.Subject.PostalCode,
+		SerialNumber:       serialNumber.String(),
+	}
+
+	if len(creationInfo.SubjectSerialNumber) != 0 {
+		subject.SerialNumber = creationInfo.SubjectSerialNumber
+	}
+
+	// The common name may have been moved to the IP SANs, leaving only
+	// the alternative names, if any
+	dnsNames := creationInfo.CommonNames
+	if len(creationInfo.CommonNames) != 0 && !creationInfo.OmitCommonName {
+		subject.CommonName = creationInfo.CommonNames[0]
+		if creationInfo.ExcludeCNFromSANs {
+			dnsNames = nil
+			for _, v := range creationInfo.CommonNames {
+				if v != subject.CommonName {
+					dnsNames = append(dnsNames, v)
+				}
+			}
+		}
+	}
+
+	if len(creationInfo.Organization) != 0 {
+		subject.Organization = []string{creationInfo.Organization}
+	}
+	if len(creationInfo.OrganizationalUnit) != 0 {
+		subject.OrganizationalUnit = []string{creationInfo.OrganizationalUnit}
+	}
+	if len(creationInfo.Country) != 0 {
+		subject.Country = creationInfo.Country
+	}
+	if len(creationInfo.Locality) != 0 {
+		subject.Locality = creationInfo.Locality
+	}
+	if len(creationInfo.Province) != 0 {
+		subject.Province = creationInfo.Province
+	}
+	if len(creationInfo.PostalCode) != 0 {
+		subject.PostalCode = creationInfo.PostalCode
+	}
+
+	if creationInfo.EnforceSubjectLengths {
+		if err := validateSubjectLengths(subject); err != nil {
+			return nil, err
+		}
+	}
+
+	sigAlg, err := signatureAlgorithm(creationInfo.SigningBundle.PrivateKey, creationInfo.SignatureBits)
+	if err != nil {
+		return nil, err
+	}
+
+	// Backdating allows for clients whose clocks are behind, but never
+	// before the validity of the CA itself
+	now := creationInfo.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	notBefore := now.Add(-creationInfo.NotBeforeDuration)
+	if notBefore.Before(creationInfo.CACert.NotBefore) {
+		notBefore = creationInfo.CACert.NotBefore
+	}
+
+	certTemplate := &x509.Certificate{
+		SignatureAlgorithm:          sigAlg,
+		SerialNumber:                serialNumber,
+		Subject:                     subject,
+		NotBefore:                   notBefore,
+		NotAfter:                    now.Add(creationInfo.TTL),
+		KeyUsage:                    x509.KeyUsage(x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement),
+		BasicConstraintsValid:       true,
+		IsCA:                        false,
+		SubjectKeyId:                subjKeyID,
+		DNSNames:                    dnsNames,
+		IPAddresses:                 creationInfo.IPSANs,
+		URIs:                        creationInfo.URISANs,
+		PermittedDNSDomainsCritical: false,
+		PermittedDNSDomains:         nil,
+		CRLDistributionPoints:       creationInfo.CACert.CRLDistributionPoints,
+	}
+
+	// Configured CRL distribution points replace those of the CA
+	if creationInfo.URLs != nil {
+		certTemplate.IssuingCertificateURL = creationInfo.URLs.IssuingCertificates
+		certTemplate.OCSPServer = creationInfo.URLs.OCSPServers
+		if len(creationInfo.URLs.CRLDistributionPoints) != 0 {
+			certTemplate.CRLDistributionPoints = creationInfo.URLs.CRLDistributionPoints
+		}
+	}
+
+	// Ed25519 keys can only be used for signatures (RFC 8410 section 5)
+	if _, ok := clientPubKey.(ed25519.PublicKey); ok {
+		certTemplate.KeyUsage = x509.KeyUsageDigitalSignature
+	}
+
+	if creationInfo.KeyUsage != 0 {
+		certTemplate.KeyUsage = creationInfo.KeyUsage
+	}
+
+	certTemplate.PolicyIdentifiers = creationInfo.PolicyIdentifiers
+
+	if creationInfo.Usage&serverUsage != 0 {
+		certTemplate.ExtKeyUsage = append(certTemplate.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+	}
+	if creationInfo.Usage&clientUsage != 0 {
+		certTemplate.ExtKeyUsage = append(certTemplate.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+	}
+	if creationInfo.Usage&codeSigningUsage != 0 {
+		certTemplate.ExtKeyUsage = append(certTemplate.ExtKeyUsage, x509.ExtKeyUsageCodeSigning)
+	}
+	if creationInfo.Usage&emailProtectionUsage != 0 {
+		certTemplate.ExtKeyUsage = append(certTemplate.ExtKeyUsage, x509.ExtKeyUsageEmailProtection)
+	}
+	if creationInfo.Usage&timeStampingUsage != 0 {
+		certTemplate.ExtKeyUsage = append(certTemplate.ExtKeyUsage, x509.ExtKeyUsageTimeStamping)
+	}
+	if creationInfo.Usage&ocspSigningUsage != 0 {
+		certTemplate.ExtKeyUsage = append(certTemplate.ExtKeyUsage, x509.ExtKeyUsageOCSPSigning)
+	}
+
+	// crypto/x509 leaves out its own SAN extension when one is given
+	if len(creationInfo.OtherSANs) != 0 {
+		sans, err := marshalSANs(dnsNames, creationInfo.IPSANs, creationInfo.URISANs, creationInfo.OtherSANs)
+		if err != nil {
+			return nil, certutil.InternalError{Err: fmt.Sprintf("Unable to encode Subject Alternative Names: %s", err)}
+		}
+		certTemplate.ExtraExtensions = append(certTemplate.ExtraExtensions, pkix.Extension{
+			Id:       subjectAltNameOID,
+			Critical: len(subject.ToRDNSequence()) == 0,
+			Value:    sans,
+		})
+	}
+
+	if creationInfo.Precertificate {
+		certTemplate.ExtraExtensions = append(certTemplate.ExtraExtensions, pkix.Extension{
+			Id:       ctPoisonOID,
+			Critical: true,
+			Value:    asn1.NullBytes,
+		})
+	}
+
+	if len(creationInfo.Comment) != 0 {
+		comment, err := asn1.Marshal(asn1.RawValue{
+			Tag:   asn1.TagIA5String,
+			Bytes: []byte(creationInfo.Comment),
+		})
+		if err != nil {
+			return nil, certutil.InternalError{Err: fmt.Sprintf("Unable to encode certificate comment: %s", err)}
+		}
+		certTemplate.ExtraExtensions = append(certTemplate.ExtraExtensions, pkix.Extension{
+			Id:    netscapeCommentOID,
+			Value: comment,
+		})
+	}
+
+	// Go adds the authority key identifier whenever the parent has a
+	// subject key identifier, so hide it on a copy of the CA certificate.
+	// Otherwise the template's is used, which covers CA certificates
+	// without one.
+	parentCert := creationInfo.CACert
+	if creationInfo.OmitAuthorityKeyID {
+		parentCopy := *creationInfo.CACert
+		parentCopy.SubjectKeyId = nil
+		parentCert = &parentCopy
+	} else {
+		certTemplate.AuthorityKeyId = creationInfo.AuthorityKeyID
+	}
+
+	cert, err := x509.CreateCertificate(rand.Reader, certTemplate, parentCert, clientPubKey, creationInfo.SigningBundle.PrivateKey)
+	if err != nil {
+		return nil, certutil.InternalError{Err: fmt.Sprintf("Unable to create certificate: %s", err)}
+	}
+
+	result.CertificateBytes = cert
+	result.Certificate, err = x509.ParseCertificate(cert)
+	if err != nil {
+		return nil, certutil.InternalError{Err: fmt.Sprintf("Unable to parse created certificate: %s", err)}
+	}
+
+	result.IssuingCABytes = creationInfo.SigningBundle.CertificateBytes
+	result.IssuingCA = creationInfo.SigningBundle.Certificate
+
+	return result, nil
+}
+
+// Issues the final certificate for a Certificate Transparency
+// precertificate. Its TBSCertificate must be the precertificate's with the
+// SCT list extension in place of the poison extension (RFC 6962 section
+// 3.1), so every extension is given explicitly and in order, leaving
+// crypto/x509 to add none of its own.
+func createCertificateFromPrecertificate(signingBundle *caInfoBundle, precert *x509.Certificate, scts [][]byte) (*certutil.ParsedCertBundle, error) {
+	sctList, err := marshalSCTList(scts)
+	if err != nil {
+		return nil, err
+	}
+
+	var extensions []pkix.Extension
+	poisoned := false
+	for _, ext := range precert.Extensions {
+		if ext.Id.Equal(ctPoisonOID) {
+			ext = pkix.Extension{
+				Id:    ctSCTListOID,
+				Value: sctList,
+			}
+			poisoned = true
+		}
+		extensions = append(extensions, ext)
+	}
+	if !poisoned {
+		return nil, certutil.InternalError{Err: "Stored precertificate has no poison extension"}
+	}
+
+	certTemplate := &x509.Certificate{
+		SignatureAlgorithm: precert.SignatureAlgorithm,
+		SerialNumber:       precert.SerialNumber,
+		RawSubject:         precert.RawSubject,
+		NotBefore:          precert.NotBefore,
+		NotAfter:           precert.NotAfter,
+		ExtraExtensions:    extensions,
+	}
+
+	// Go adds the authority key identifier whenever the parent has a
+	// subject key identifier; the precertificate's, if any, is among the
+	// extensions already
+	parentCopy := *signingBundle.Certificate
+	parentCopy.SubjectKeyId = nil
+
+	cert, err := x509.CreateCertificate(rand.Reader, certTemplate, &parentCopy, precert.PublicKey, signingBundle.PrivateKey)
+	if err != nil {
+		return nil, certutil.InternalError{Err: fmt.Sprintf("Unable to create certificate: %s", err)}
+	}
+
+	result := &certutil.ParsedCertBundle{
+		CertificateBytes: cert,
+		IssuingCABytes:   signingBundle.CertificateBytes,
+		IssuingCA:        signingBundle.Certificate,
+	}
+	result.Certificate, err = x509.ParseCertificate(cert)
+	if err != nil {
+		return nil, certutil.InternalError{Err: fmt.Sprintf("Unable to parse created certificate: %s", err)}
+	}
+
+	return result, nil
+}
+
+// Checks whether the certificate is self-signed, and so a root
+func isSelfSigned(cert *x509.Certificate) bool {
+	if !bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+		return false
+	}
+	return cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+// Returns the Subject Alternative Names of the certificate, broken down by
+// type, for confirmation in responses
+func certificateSANs(cert *x509.Certificate) map[string][]string {
+	return formatSANs(cert.DNSNames, cert.EmailAddresses, cert.IPAddresses, cert.URIs)
+}
+
+func formatSANs(dnsNames, emailAddresses []string, ipAddresses []net.IP, uris []*url.URL) map[string][]string {
+	sans := map[string][]string{
+		"dns":   []string{},
+		"ip":    []string{},
+		"email": []string{},
+		"uri":   []string{},
+	}
+
+	sans["dns"] = append(sans["dns"], dnsNames...)
+	sans["email"] = append(sans["email"], emailAddresses...)
+	for _, ip := range ipAddresses {
+		sans["ip"] = append(sans["ip"], ip.String())
+	}
+	for _, uri := range uris {
+		sans["uri"] = append(sans["uri"], uri.String())
+	}
+
+	return sans
+}
+
+// Encodes the given serialized SCTs as the value of the SCT list extension:
+// a TLS-encoded SignedCertificateTimestampList wrapped in an OCTET STRING
+func marshalSCTList(scts [][]byte) ([]byte, error) {
+	var list []byte
+	for _, sct := range scts {
+		if len(sct) == 0 || len(sct) > 0xffff {
+			return nil, certutil.UserError{Err: fmt.Sprintf("Invalid SCT length: %d", len(sct))}
+		}
+		list = append(list, byte(len(sct)>>8), byte(len(sct)))
+		list = append(list, sct...)
+	}
+	if len(list) > 0xffff {
+		return nil, certutil.UserError{Err: fmt.Sprintf("SCT list is too long: %d bytes", len(list))}
+	}
+
+	tlsEncoded := append([]byte{byte(len(list) >> 8), byte(len(list))}, list...)
+	value, err := asn1.Marshal(tlsEncoded)
+	if err != nil {
+		return nil, certutil.InternalError{Err: fmt.Sprintf("Error marshalling SCT list: %s", err)}
+	}
+
+	return value, nil
 }
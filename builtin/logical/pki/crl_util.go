@@ -5,6 +5,7 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/vault/helper/certutil"
@@ -16,7 +17,8 @@ type revocationInfo struct {
 	RevocationTime   int64  `json:"revocation_time"`
 }
 
-// Revokes a cert, and tries to be smart about error recovery
+// Revokes a cert, and tries to be smart about error recovery. The caller
+// must hold revokeStorageLock.
 func revokeCert(b *backend, req *logical.Request, serial string) (*logical.Response, error) {
 	alreadyRevoked := false
 	var revInfo revocationInfo
@@ -98,6 +100,10 @@ func revokeCert(b *backend, req *logical.Request, serial string) (*logical.Respo
 		return nil, fmt.Errorf("Error deleting cert from valid-certs location")
 	}
 
+	if cert, err := x509.ParseCertificate(revInfo.CertificateBytes); err == nil {
+		b.notify(req, "revoke", cert, "")
+	}
+
 	return &logical.Response{
 		Data: map[string]interface{}{
 			"revocation_time": revInfo.RevocationTime,
@@ -105,11 +111,85 @@ func revokeCert(b *backend, req *logical.Request, serial string) (*logical.Respo
 	}, nil
 }
 
+// Revokes every unexpired stored certificate with the given common name,
+// other than the one with the given serial, which must already be stored.
+// The CRL is built once for all of them. A non-nil response is only
+// returned on error.
+func revokeCertsByCN(b *backend, req *logical.Request, cn, keepSerial string) (*logical.Response, error) {
+	b.revokeStorageLock.Lock()
+	defer b.revokeStorageLock.Unlock()
+
+	serials, err := req.Storage.List("certs/")
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching list of certs: %s", err)
+	}
+
+	var revokedSerials []string
+	var revokedCerts []*x509.Certificate
+	for _, serial := range serials {
+		if serial == keepSerial {
+			continue
+		}
+
+		certEntry, err := req.Storage.Get("certs/" + serial)
+		if err != nil {
+			return nil, fmt.Errorf("Error fetching certificate with serial %s: %s", serial, err)
+		}
+		if certEntry == nil {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(certEntry.Value)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse stored certificate with serial %s: %s", serial, err)
+		}
+
+		if !strings.EqualFold(cert.Subject.CommonName, cn) || cert.NotAfter.Before(time.Now()) {
+			continue
+		}
+
+		revEntry, err := logical.StorageEntryJSON("revoked/"+serial, revocationInfo{
+			CertificateBytes: certEntry.Value,
+			RevocationTime:   time.Now().Unix(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Error creating revocation entry")
+		}
+		if err := req.Storage.Put(revEntry); err != nil {
+			return nil, fmt.Errorf("Error saving revoked certificate to new location")
+		}
+
+		revokedSerials = append(revokedSerials, serial)
+		revokedCerts = append(revokedCerts, cert)
+	}
+
+	if len(revokedSerials) == 0 {
+		return nil, nil
+	}
+
+	crlErr := buildCRL(b, req)
+	switch crlErr.(type) {
+	case certutil.UserError:
+		return logical.ErrorResponse(fmt.Sprintf("Error during CRL building: %s", crlErr)), nil
+	case certutil.InternalError:
+		return nil, fmt.Errorf("Error encountered during CRL building: %s", crlErr)
+	}
+
+	for i, serial := range revokedSerials {
+		if err := req.Storage.Delete("certs/" + serial); err != nil {
+			return nil, fmt.Errorf("Error deleting cert from valid-certs location")
+		}
+		b.notify(req, "revoke", revokedCerts[i], "")
+	}
+
+	return nil, nil
+}
+
 // Builds a CRL by going through the list of revoked certificates and building
 // a new CRL with the stored revocation times and serial numbers.
 //
 // If a certificate has already expired, it will be removed entirely rather than
-// become part of the new CRL.
+// become part of the new CRL. The caller must hold revokeStorageLock.
 func buildCRL(b *backend, req *logical.Request) error {
 	revokedSerials, err := req.Storage.List("revoked/")
 	if err != nil {
@@ -157,7 +237,7 @@ func buildCRL(b *backend, req *logical.Request) error {
 		})
 	}
 
-	signingBundle, caErr := fetchCAInfo(req)
+	signingBundle, caErr := b.fetchCAInfo(req)
 	switch caErr.(type) {
 	case certutil.UserError:
 		return certutil.UserError{Err: fmt.Sprintf("Could not fetch the CA certificate: %s", caErr)}
@@ -178,6 +258,8 @@ func buildCRL(b *backend, req *logical.Request) error {
 		crlLifetime = crlDur
 	}
 
+	// The signature algorithm follows the CA key: SHA-256 with RSA, or
+	// ECDSA with a hash matched to the curve
 	crlBytes, err := signingBundle.Certificate.CreateCRL(rand.Reader, signingBundle.PrivateKey, revokedCerts, time.Now(), time.Now().Add(crlLifetime))
 	if err != nil {
 		return certutil.InternalError{Err: fmt.Sprintf("Error creating new CRL: %s", err)}
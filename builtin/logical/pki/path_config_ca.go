@@ -1,7 +1,11 @@
 package pki
 
 import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
+	"time"
 
 	"github.com/hashicorp/vault/helper/certutil"
 	"github.com/hashicorp/vault/logical"
@@ -17,9 +21,34 @@ func pathConfigCA(b *backend) *framework.Path {
 				Description: `PEM-format, concatenated unencrypted secret key
 and certificate`,
 			},
+
+			"verify_chain": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If set, the certificate must verify against
+the root given in "root_pem", or, if that is not
+set, the root stored by a previous verified write.
+A self-signed certificate with no root available
+is verified against itself.`,
+			},
+
+			"reject_sha1": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If set, a CA certificate signed with a SHA-1
+based algorithm is rejected. Otherwise it is
+accepted with a warning.`,
+			},
+
+			"root_pem": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `PEM-format root certificate(s) to verify the
+CA certificate against when "verify_chain" is set.
+On success, these are stored for later
+verifications.`,
+			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:  b.pathCARead,
 			logical.WriteOperation: b.pathCAWrite,
 		},
 
@@ -28,6 +57,33 @@ and certificate`,
 	}
 }
 
+func (b *backend) pathCARead(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	caInfo, caErr := b.fetchCAInfo(req)
+	switch caErr.(type) {
+	case certutil.UserError:
+		return logical.ErrorResponse(fmt.Sprintf("Could not fetch the CA certificate: %s", caErr)), nil
+	case certutil.InternalError:
+		return nil, fmt.Errorf("Error fetching CA certificate: %s", caErr)
+	}
+
+	cert := caInfo.Certificate
+	keyType, keyBits := publicKeyTypeBits(cert.PublicKey)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"subject":         cert.Subject.String(),
+			"issuer":          cert.Issuer.String(),
+			"serial_number":   certutil.GetOctalFormatted(cert.SerialNumber.Bytes(), ":"),
+			"not_before":      cert.NotBefore.UTC().Format(time.RFC3339),
+			"not_after":       cert.NotAfter.UTC().Format(time.RFC3339),
+			"key_type":        keyType,
+			"key_bits":        keyBits,
+			"has_private_key": caInfo.PrivateKey != nil,
+		},
+	}, nil
+}
+
 func (b *backend) pathCAWrite(
 	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	pemBundle := d.Get("pem_bundle").(string)
@@ -48,23 +104,89 @@ func (b *backend) pathCAWrite(
 		parsedBundle.CertificateBytes = parsedBundle.IssuingCABytes
 	}
 
-	// TODO?: CRLs can only be generated with RSA keys right now, in the
-	// Go standard library. The plubming is here to support non-RSA keys
-	// if the library gets support
-
-	if parsedBundle.PrivateKeyType != certutil.RSAPrivateKey {
-		return logical.ErrorResponse("Currently, only RSA keys are supported for the CA certificate"), nil
+	// Both certificates and CRLs are signed with the CA key, so it must be
+	// of a type that both can be signed with
+	switch parsedBundle.PrivateKeyType {
+	case certutil.RSAPrivateKey, certutil.ECPrivateKey:
+	default:
+		return logical.ErrorResponse("Only RSA and EC keys are supported for the CA certificate"), nil
 	}
 
 	if !parsedBundle.Certificate.IsCA {
 		return logical.ErrorResponse("The given certificate is not marked for CA use and cannot be used with this backend"), nil
 	}
 
+	// Intermediates often come with the rest of their chain, which goes
+	// beyond the single issuing CA the bundle holds
+	chain, err := orderCAChain(parsedBundle, pemBundle)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	var chainPEM []byte
+	if len(chain) > 1 {
+		parsedBundle.IssuingCA = chain[1]
+		parsedBundle.IssuingCABytes = chain[1].Raw
+	}
+	if len(chain) > 2 {
+		for _, cert := range chain[2:] {
+			chainPEM = append(chainPEM, pem.EncodeToMemory(&pem.Block{
+				Type:  "CERTIFICATE",
+				Bytes: cert.Raw,
+			})...)
+		}
+	}
+
+	var resp *logical.Response
+	switch parsedBundle.Certificate.SignatureAlgorithm {
+	case x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		if d.Get("reject_sha1").(bool) {
+			return logical.ErrorResponse("The CA certificate is signed with a SHA-1 based algorithm"), nil
+		}
+		resp = &logical.Response{}
+		resp.AddWarning("The CA certificate is signed with a SHA-1 based algorithm; chains including it are rejected or flagged by many modern validators")
+	}
+
+	var rootPEM string
+	if d.Get("verify_chain").(bool) {
+		rootPEM = d.Get("root_pem").(string)
+		if len(rootPEM) == 0 {
+			rootEntry, err := req.Storage.Get("config/ca_root")
+			if err != nil {
+				return nil, err
+			}
+			if rootEntry != nil {
+				rootPEM = string(rootEntry.Value)
+			}
+		}
+
+		err := verifyCAChain(chain, rootPEM)
+		switch err.(type) {
+		case certutil.UserError:
+			return logical.ErrorResponse(err.Error()), nil
+		case certutil.InternalError:
+			return nil, err
+		}
+	}
+
 	cb, err := parsedBundle.ToCertBundle()
 	if err != nil {
 		return nil, fmt.Errorf("Error converting raw values into cert bundle: %s", err)
 	}
 
+	// The chain is written first, so that it is in place by the time the
+	// new bundle can be loaded
+	if len(chainPEM) != 0 {
+		err = req.Storage.Put(&logical.StorageEntry{
+			Key:   "config/ca_chain",
+			Value: chainPEM,
+		})
+	} else {
+		err = req.Storage.Delete("config/ca_chain")
+	}
+	if err != nil {
+		return nil, err
+	}
+
 	entry, err := logical.StorageEntryJSON("config/ca_bundle", cb)
 	if err != nil {
 		return nil, err
@@ -73,9 +195,10 @@ func (b *backend) pathCAWrite(
 	if err != nil {
 		return nil, err
 	}
+	b.invalidateCAInfo()
 
 	// For ease of later use, also store just the certificate at a known
-	// location, plus a blank CRL
+	// location
 	entry.Key = "ca"
 	entry.Value = parsedBundle.CertificateBytes
 	err = req.Storage.Put(entry)
@@ -83,14 +206,118 @@ func (b *backend) pathCAWrite(
 		return nil, err
 	}
 
-	entry.Key = "crl"
-	entry.Value = []byte{}
-	err = req.Storage.Put(entry)
+	// Build the CRL right away, so that a valid one signed by this CA can
+	// be fetched before anything has been revoked
+	b.revokeStorageLock.Lock()
+	defer b.revokeStorageLock.Unlock()
+
+	crlErr := buildCRL(b, req)
+	switch crlErr.(type) {
+	case certutil.UserError:
+		return logical.ErrorResponse(fmt.Sprintf("Error during CRL building: %s", crlErr)), nil
+	case certutil.InternalError:
+		return nil, fmt.Errorf("Error encountered during CRL building: %s", crlErr)
+	}
+
+	if len(rootPEM) != 0 {
+		err = req.Storage.Put(&logical.StorageEntry{
+			Key:   "config/ca_root",
+			Value: []byte(rootPEM),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+// Orders the certificates of the PEM bundle into the chain of the CA
+// certificate, which comes first, each followed by its issuer. Every
+// certificate in the bundle must be part of that chain.
+func orderCAChain(parsedBundle *certutil.ParsedCertBundle, pemBundle string) ([]*x509.Certificate, error) {
+	var remaining []*x509.Certificate
+	rest := []byte(pemBundle)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		certs, err := x509.ParseCertificates(block.Bytes)
+		if err != nil {
+			// Private keys have already been handled by ParsePEMBundle
+			continue
+		}
+		for _, cert := range certs {
+			if !bytes.Equal(cert.Raw, parsedBundle.Certificate.Raw) {
+				remaining = append(remaining, cert)
+			}
+		}
+	}
+
+	chain := []*x509.Certificate{parsedBundle.Certificate}
+	for len(remaining) != 0 {
+		current := chain[len(chain)-1]
+		if current.CheckSignatureFrom(current) == nil {
+			break
+		}
+
+		found := -1
+		for i, cert := range remaining {
+			if current.CheckSignatureFrom(cert) == nil {
+				found = i
+				break
+			}
+		}
+		if found == -1 {
+			break
+		}
+		chain = append(chain, remaining[found])
+		remaining = append(remaining[:found], remaining[found+1:]...)
+	}
+
+	if len(remaining) != 0 {
+		return nil, certutil.UserError{Err: fmt.Sprintf(
+			"The certificate for %s in the bundle is not part of the chain of the CA certificate", remaining[0].Subject.CommonName)}
+	}
+
+	return chain, nil
+}
+
+// Verifies that the CA certificate, first in the chain, chains to one of
+// the given PEM-format roots, using the rest of the chain as
+// intermediates. A self-signed certificate is verified against itself if
+// no roots are given.
+func verifyCAChain(chain []*x509.Certificate, rootPEM string) error {
+	caCert := chain[0]
+	roots := x509.NewCertPool()
+	if len(rootPEM) != 0 {
+		if !roots.AppendCertsFromPEM([]byte(rootPEM)) {
+			return certutil.UserError{Err: "No valid certificates found in root_pem"}
+		}
+	} else {
+		if caCert.CheckSignatureFrom(caCert) != nil {
+			return certutil.UserError{Err: "The CA certificate is not self-signed and no root certificate was provided or previously stored"}
+		}
+		roots.AddCert(caCert)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := caCert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
 	if err != nil {
-		return nil, err
+		return certutil.UserError{Err: fmt.Sprintf("The CA certificate does not verify against the root: %s", err)}
 	}
 
-	return nil, nil
+	return nil
 }
 
 const pathConfigCAHelpSyn = `
@@ -100,7 +327,19 @@ Configure the CA certificate and private key used for generated credentials.
 const pathConfigCAHelpDesc = `
 This configures the CA information used for credentials
 generated by this backend. This must be a PEM-format, concatenated
-unencrypted secret key and certificate.
+unencrypted secret key and certificate. The certificates of the rest of
+the CA's chain may be included in any order; they are returned in the
+"ca_chain" of issued certificates.
+
+If "verify_chain" is set, the certificate must verify against the root
+certificate(s) in "root_pem" or, if that is not given, the root stored by
+a previous verified write. This catches intermediates that do not chain
+to the expected root before they are put into use.
+
+A CA certificate signed with a SHA-1 based algorithm is accepted with a
+warning, or rejected if "reject_sha1" is set.
 
-For security reasons, you can only view the certificate when reading this endpoint.
+Reading this endpoint returns the subject, issuer, serial number, validity
+period, and key type and size of the configured CA certificate, and
+whether a private key is present. The key itself is never returned.
 `
@@ -0,0 +1,280 @@
+package pki
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/helper/certutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// caEscrowEntry tracks the one-time escrow of the CA private key. Once
+// EscrowedAt is set, the key can never be escrowed again.
+type caEscrowEntry struct {
+	TokenHash      string    `json:"token_hash" structs:"token_hash" mapstructure:"token_hash"`
+	EscrowedAt     time.Time `json:"escrowed_at" structs:"escrowed_at" mapstructure:"escrowed_at"`
+	EscrowedBy     string    `json:"escrowed_by" structs:"escrowed_by" mapstructure:"escrowed_by"`
+	CASerialNumber string    `json:"ca_serial_number" structs:"ca_serial_number" mapstructure:"ca_serial_number"`
+}
+
+func pathConfigCAEscrowToken(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/ca/escrow_token",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.WriteOperation: b.pathCAEscrowTokenWrite,
+		},
+
+		HelpSynopsis:    pathConfigCAEscrowTokenHelpSyn,
+		HelpDescription: pathConfigCAEscrowTokenHelpDesc,
+	}
+}
+
+func pathConfigCAEscrow(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/ca/escrow",
+		Fields: map[string]*framework.FieldSchema{
+			"token": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `The one-time escrow token`,
+			},
+
+			"public_key": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `PEM-format RSA public key of the recipient;
+the CA private key is returned encrypted to it`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:  b.pathCAEscrowRead,
+			logical.WriteOperation: b.pathCAEscrowWrite,
+		},
+
+		HelpSynopsis:    pathConfigCAEscrowHelpSyn,
+		HelpDescription: pathConfigCAEscrowHelpDesc,
+	}
+}
+
+func fetchCAEscrow(req *logical.Request) (*caEscrowEntry, error) {
+	entry, err := req.Storage.Get("config/ca_escrow")
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var escrow caEscrowEntry
+	if err := entry.DecodeJSON(&escrow); err != nil {
+		return nil, fmt.Errorf("Unable to decode CA escrow state: %s", err)
+	}
+
+	return &escrow, nil
+}
+
+func storeCAEscrow(req *logical.Request, escrow *caEscrowEntry) error {
+	entry, err := logical.StorageEntryJSON("config/ca_escrow", escrow)
+	if err != nil {
+		return err
+	}
+	return req.Storage.Put(entry)
+}
+
+func (b *backend) pathCAEscrowTokenWrite(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.caEscrowLock.Lock()
+	defer b.caEscrowLock.Unlock()
+
+	escrow, err := fetchCAEscrow(req)
+	if err != nil {
+		return nil, err
+	}
+	if escrow != nil && !escrow.EscrowedAt.IsZero() {
+		return logical.ErrorResponse("The CA private key has already been escrowed"), nil
+	}
+
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, fmt.Errorf("Error generating escrow token: %s", err)
+	}
+	token := hex.EncodeToString(tokenBytes)
+	tokenHash := sha256.Sum256([]byte(token))
+
+	// Registering a new token replaces any unused one
+	err = storeCAEscrow(req, &caEscrowEntry{
+		TokenHash: hex.EncodeToString(tokenHash[:]),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"token": token,
+		},
+	}, nil
+}
+
+func (b *backend) pathCAEscrowRead(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	escrow, err := fetchCAEscrow(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"token_registered": escrow != nil && escrow.EscrowedAt.IsZero(),
+			"escrowed":         escrow != nil && !escrow.EscrowedAt.IsZero(),
+		},
+	}
+	if escrow != nil && !escrow.EscrowedAt.IsZero() {
+		resp.Data["escrowed_at"] = escrow.EscrowedAt.Format(time.RFC3339)
+		resp.Data["escrowed_by"] = escrow.EscrowedBy
+		resp.Data["ca_serial_number"] = escrow.CASerialNumber
+	}
+
+	return resp, nil
+}
+
+func (b *backend) pathCAEscrowWrite(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	token := data.Get("token").(string)
+	if len(token) == 0 {
+		return logical.ErrorResponse("The escrow token must be provided"), nil
+	}
+
+	pemBlock, _ := pem.Decode([]byte(data.Get("public_key").(string)))
+	if pemBlock == nil {
+		return logical.ErrorResponse("A PEM-format public key must be provided"), nil
+	}
+	parsedKey, err := x509.ParsePKIXPublicKey(pemBlock.Bytes)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("Unable to parse public key: %s", err)), nil
+	}
+	publicKey, ok := parsedKey.(*rsa.PublicKey)
+	if !ok {
+		return logical.ErrorResponse("Only RSA public keys are supported for escrow"), nil
+	}
+
+	// Checked up front, since the escrow is used up before encrypting
+	if publicKey.Size() < 2*sha256.Size+2+32 {
+		return logical.ErrorResponse("The public key is too small to encrypt to"), nil
+	}
+
+	b.caEscrowLock.Lock()
+	defer b.caEscrowLock.Unlock()
+
+	escrow, err := fetchCAEscrow(req)
+	if err != nil {
+		return nil, err
+	}
+	if escrow == nil {
+		return logical.ErrorResponse("No escrow token has been registered"), nil
+	}
+	if !escrow.EscrowedAt.IsZero() {
+		return logical.ErrorResponse("The CA private key has already been escrowed"), nil
+	}
+
+	tokenHash := sha256.Sum256([]byte(token))
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(tokenHash[:])), []byte(escrow.TokenHash)) != 1 {
+		return logical.ErrorResponse("Invalid escrow token"), nil
+	}
+
+	caBundle, caErr := b.fetchCAInfo(req)
+	switch caErr.(type) {
+	case certutil.UserError:
+		return logical.ErrorResponse(fmt.Sprintf("Could not fetch the CA certificate: %s", caErr)), nil
+	case certutil.InternalError:
+		return nil, fmt.Errorf("Error fetching CA certificate: %s", caErr)
+	}
+	cb, err := caBundle.ToCertBundle()
+	if err != nil {
+		return nil, fmt.Errorf("Error converting CA bundle: %s", err)
+	}
+
+	// Mark the key as escrowed before encrypting it, so that no failure
+	// past this point can result in it being handed out twice
+	escrow.TokenHash = ""
+	escrow.EscrowedAt = time.Now().UTC()
+	escrow.EscrowedBy = req.DisplayName
+	escrow.CASerialNumber = cb.SerialNumber
+	if err := storeCAEscrow(req, escrow); err != nil {
+		return nil, err
+	}
+
+	// The key is encrypted with a random AES-256-GCM key, which is in turn
+	// encrypted to the recipient with RSA-OAEP
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("Error generating escrow data key: %s", err)
+	}
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("Error generating escrow nonce: %s", err)
+	}
+	encryptedKey := gcm.Seal(nil, nonce, []byte(cb.PrivateKey), nil)
+
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, dataKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to encrypt to the given public key: %s", err)
+	}
+
+	b.Logger().Printf("[WARN] pki: CA private key with serial %s escrowed by %q", cb.SerialNumber, req.DisplayName)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"encrypted_private_key": base64.StdEncoding.EncodeToString(encryptedKey),
+			"nonce":                 base64.StdEncoding.EncodeToString(nonce),
+			"wrapped_key":           base64.StdEncoding.EncodeToString(wrappedKey),
+			"ca_serial_number":      cb.SerialNumber,
+		},
+	}, nil
+}
+
+const pathConfigCAEscrowTokenHelpSyn = `
+Register a one-time token for escrowing the CA private key.
+`
+
+const pathConfigCAEscrowTokenHelpDesc = `
+Writing to this endpoint generates a new one-time escrow token and
+returns it; only a hash of it is stored. Registering a new token replaces
+any unused one. Once the CA private key has been escrowed, no new token
+can be registered.
+`
+
+const pathConfigCAEscrowHelpSyn = `
+Escrow the CA private key exactly once.
+`
+
+const pathConfigCAEscrowHelpDesc = `
+This is a deliberate escape hatch for disaster recovery. Writing the
+one-time token from "config/ca/escrow_token" along with a recipient RSA
+public key returns the CA private key, PEM-encoded and encrypted with
+AES-256-GCM under a random key, which is itself encrypted to the
+recipient with RSA-OAEP (SHA-256). The key is permanently marked as
+escrowed, and every further escrow attempt is refused.
+
+Reading this endpoint returns whether a token is registered and, once
+escrowed, when and by whom.
+`
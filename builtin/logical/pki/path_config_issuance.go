@@ -0,0 +1,190 @@
+package pki
+
+import (
+	"fmt"
+
+	"github.com/fatih/structs"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// issuanceConfig holds backend-wide settings applied to every issued
+// certificate
+type issuanceConfig struct {
+	EnforceSubjectLengths bool   `json:"enforce_subject_lengths" mapstructure:"enforce_subject_lengths" structs:"enforce_subject_lengths"`
+	FixedLengthSerials    bool   `json:"fixed_length_serials" mapstructure:"fixed_length_serials" structs:"fixed_length_serials"`
+	IPCommonNames         string `json:"ip_common_names" mapstructure:"ip_common_names" structs:"ip_common_names"`
+	SerialBits            int    `json:"serial_bits" mapstructure:"serial_bits" structs:"serial_bits"`
+}
+
+// Serial numbers are random and positive, so 159 bits is the most that
+// fits in the 20 octets allowed by RFC 5280. The CA/Browser Forum
+// requires at least 64 bits of entropy.
+const (
+	defaultSerialBits = 159
+	minSerialBits     = 64
+)
+
+func pathConfigIssuance(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/issuance",
+		Fields: map[string]*framework.FieldSchema{
+			"enforce_subject_lengths": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If set, issuance fails when a subject
+attribute such as the common name exceeds its
+X.520 upper bound; defaults to false`,
+			},
+
+			"fixed_length_serials": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If set, the highest bit of the random serial
+number is always set, so that every serial is
+DER-encoded in the same number of octets, 20 with
+the default serial_bits. This leaves one random bit
+fewer, so serial_bits must be at least 65; defaults
+to false`,
+			},
+
+			"serial_bits": &framework.FieldSchema{
+				Type:    framework.TypeInt,
+				Default: defaultSerialBits,
+				Description: `The number of random bits in serial numbers,
+from 64 to 159; defaults to 159`,
+			},
+
+			"ip_common_names": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "warn",
+				Description: `How to handle a common name that is an IP
+address: "warn" issues it with a warning, "reject"
+refuses it, and "move" moves it to the IP SANs
+(subject to allow_ip_sans); defaults to "warn"`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:  b.pathIssuanceRead,
+			logical.WriteOperation: b.pathIssuanceWrite,
+		},
+
+		HelpSynopsis:    pathConfigIssuanceHelpSyn,
+		HelpDescription: pathConfigIssuanceHelpDesc,
+	}
+}
+
+// Returns the issuance configuration, or the defaults if none has been
+// written
+func (b *backend) Issuance(s logical.Storage) (*issuanceConfig, error) {
+	entry, err := s.Get("config/issuance")
+	if err != nil {
+		return nil, err
+	}
+
+	result := issuanceConfig{
+		IPCommonNames: "warn",
+		SerialBits:    defaultSerialBits,
+	}
+	if entry == nil {
+		return &result, nil
+	}
+
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	// Configurations written before the settings existed
+	if len(result.IPCommonNames) == 0 {
+		result.IPCommonNames = "warn"
+	}
+	if result.SerialBits == 0 {
+		result.SerialBits = defaultSerialBits
+	}
+
+	return &result, nil
+}
+
+func (b *backend) pathIssuanceRead(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.Issuance(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: structs.New(config).Map(),
+	}, nil
+}
+
+func (b *backend) pathIssuanceWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config := &issuanceConfig{
+		EnforceSubjectLengths: d.Get("enforce_subject_lengths").(bool),
+		FixedLengthSerials:    d.Get("fixed_length_serials").(bool),
+		IPCommonNames:         d.Get("ip_common_names").(string),
+		SerialBits:            d.Get("serial_bits").(int),
+	}
+
+	switch config.IPCommonNames {
+	case "warn", "reject", "move":
+	case "":
+		config.IPCommonNames = "warn"
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("Unknown ip_common_names handling: %s", config.IPCommonNames)), nil
+	}
+
+	if config.SerialBits < minSerialBits || config.SerialBits > defaultSerialBits {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"serial_bits must be between %d and %d", minSerialBits, defaultSerialBits)), nil
+	}
+
+	// The forced top bit is not random
+	if config.FixedLengthSerials && config.SerialBits < minSerialBits+1 {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"serial_bits must be at least %d with fixed_length_serials, which sets the top bit", minSerialBits+1)), nil
+	}
+
+	entry, err := logical.StorageEntryJSON("config/issuance", config)
+	if err != nil {
+		return nil, err
+	}
+	err = req.Storage.Put(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+const pathConfigIssuanceHelpSyn = `
+Configure backend-wide certificate issuance settings.
+`
+
+const pathConfigIssuanceHelpDesc = `
+This endpoint allows configuration of settings that apply to every
+certificate issued by this backend, regardless of role.
+
+If "enforce_subject_lengths" is set, issuance fails when any subject
+attribute exceeds its X.520 upper bound, for instance 64 characters for
+the common name, organization, and organizational unit. Some trust
+stores reject certificates with longer values.
+
+"serial_bits" sets the number of random bits in serial numbers, for
+policies that require a particular length. It must be at least 64, the
+minimum entropy required by the CA/Browser Forum, and at most 159, the
+most that keeps a positive serial within the 20 octets allowed by RFC
+5280.
+
+If "fixed_length_serials" is set, serial numbers are drawn from the upper
+half of their range, so that all of them encode to the same length for
+systems that expect uniformly-sized serials. Since the top bit is then
+always set, "serial_bits" must be at least 65 to keep 64 random bits.
+
+"ip_common_names" controls requests whose common name is an IP address,
+which many TLS clients refuse to match since they only check IP SANs.
+With "warn", the default, the certificate is issued as requested along
+with a warning. With "reject", such requests are refused. With "move",
+the address is removed from the common name and DNS SANs and added to the
+IP SANs instead, which requires the role to allow IP SANs; the first
+alternative name, if any, becomes the common name.
+`
@@ -0,0 +1,192 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fatih/structs"
+	"github.com/hashicorp/vault/helper/certutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// notificationConfig holds the endpoint that is notified of issuance and
+// revocation events
+type notificationConfig struct {
+	URL    string `json:"url" mapstructure:"url" structs:"url"`
+	Events string `json:"events" mapstructure:"events" structs:"events"`
+}
+
+// notification is the JSON payload posted to the configured endpoint
+type notification struct {
+	Event        string `json:"event"`
+	SerialNumber string `json:"serial_number"`
+	CommonName   string `json:"common_name"`
+	Role         string `json:"role,omitempty"`
+	Expiration   int64  `json:"expiration"`
+}
+
+var notificationClient = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
+func pathConfigNotifications(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/notifications",
+		Fields: map[string]*framework.FieldSchema{
+			"url": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The HTTP(S) endpoint to post notifications to;
+notifications are disabled if empty`,
+			},
+
+			"events": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "issue,revoke",
+				Description: `A comma-delimited list of the events to send
+notifications for: "issue" and "revoke"; defaults
+to both`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:  b.pathNotificationsRead,
+			logical.WriteOperation: b.pathNotificationsWrite,
+		},
+
+		HelpSynopsis:    pathConfigNotificationsHelpSyn,
+		HelpDescription: pathConfigNotificationsHelpDesc,
+	}
+}
+
+func (b *backend) Notifications(s logical.Storage) (*notificationConfig, error) {
+	entry, err := s.Get("config/notifications")
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result notificationConfig
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (b *backend) pathNotificationsRead(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.Notifications(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: structs.New(config).Map(),
+	}, nil
+}
+
+func (b *backend) pathNotificationsWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config := &notificationConfig{
+		URL:    d.Get("url").(string),
+		Events: d.Get("events").(string),
+	}
+
+	if len(config.URL) != 0 {
+		parsedURL, err := url.Parse(config.URL)
+		if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+			return logical.ErrorResponse(fmt.Sprintf("Invalid notification URL: %s", config.URL)), nil
+		}
+	}
+
+	for _, v := range strings.Split(config.Events, ",") {
+		switch strings.TrimSpace(v) {
+		case "issue", "revoke":
+		default:
+			return logical.ErrorResponse(fmt.Sprintf("Unknown notification event: %s", v)), nil
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON("config/notifications", config)
+	if err != nil {
+		return nil, err
+	}
+	err = req.Storage.Put(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Sends a notification of the given event for the certificate, if
+// configured. The post happens in the background and failures are only
+// logged, so that issuance and revocation are never blocked by it.
+func (b *backend) notify(req *logical.Request, event string, cert *x509.Certificate, role string) {
+	config, err := b.Notifications(req.Storage)
+	if err != nil {
+		b.Logger().Printf("[WARN] pki: unable to read notification config: %s", err)
+		return
+	}
+	if config == nil || len(config.URL) == 0 {
+		return
+	}
+
+	enabled := false
+	for _, v := range strings.Split(config.Events, ",") {
+		if strings.TrimSpace(v) == event {
+			enabled = true
+		}
+	}
+	if !enabled {
+		return
+	}
+
+	payload, err := json.Marshal(&notification{
+		Event:        event,
+		SerialNumber: certutil.GetOctalFormatted(cert.SerialNumber.Bytes(), ":"),
+		CommonName:   cert.Subject.CommonName,
+		Role:         role,
+		Expiration:   cert.NotAfter.Unix(),
+	})
+	if err != nil {
+		b.Logger().Printf("[WARN] pki: unable to encode %s notification: %s", event, err)
+		return
+	}
+
+	go func() {
+		resp, err := notificationClient.Post(config.URL, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			b.Logger().Printf("[WARN] pki: failed to send %s notification: %s", event, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			b.Logger().Printf("[WARN] pki: %s notification rejected with status %d", event, resp.StatusCode)
+		}
+	}()
+}
+
+const pathConfigNotificationsHelpSyn = `
+Configure notifications of certificate issuance and revocation.
+`
+
+const pathConfigNotificationsHelpDesc = `
+This endpoint configures an HTTP(S) endpoint that receives a JSON POST
+for each issued or revoked certificate, containing the event, serial
+number, common name, role (for issuance), and expiration as a Unix
+timestamp. Notifications are sent in the background on a best-effort
+basis: failures are logged but never block issuance or revocation.
+`
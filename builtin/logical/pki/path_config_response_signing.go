@@ -0,0 +1,192 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/hashicorp/vault/helper/certutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// responseSigningKeyEntry holds the key used to produce detached
+// signatures over issued certificates
+type responseSigningKeyEntry struct {
+	PrivateKey string `json:"private_key" mapstructure:"private_key" structs:"private_key"`
+}
+
+func pathConfigResponseSigning(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/response_signing_key",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.WriteOperation: b.pathResponseSigningKeyWrite,
+		},
+
+		HelpSynopsis:    pathConfigResponseSigningHelpSyn,
+		HelpDescription: pathConfigResponseSigningHelpDesc,
+	}
+}
+
+// Returns the public half of the response signing key
+func pathFetchResponseSigningKey(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "response_signing_key",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathResponseSigningKeyRead,
+		},
+
+		HelpSynopsis:    pathFetchResponseSigningKeyHelpSyn,
+		HelpDescription: pathFetchResponseSigningKeyHelpDesc,
+	}
+}
+
+// Fetches the response signing key, returning nil if none has been
+// configured
+func fetchResponseSigningKey(req *logical.Request) (*ecdsa.PrivateKey, error) {
+	entry, err := req.Storage.Get("config/response_signing_key")
+	if err != nil {
+		return nil, certutil.InternalError{Err: fmt.Sprintf("Unable to fetch response signing key: %s", err)}
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var keyEntry responseSigningKeyEntry
+	if err := entry.DecodeJSON(&keyEntry); err != nil {
+		return nil, certutil.InternalError{Err: fmt.Sprintf("Unable to decode response signing key: %s", err)}
+	}
+
+	pemBlock, _ := pem.Decode([]byte(keyEntry.PrivateKey))
+	if pemBlock == nil {
+		return nil, certutil.InternalError{Err: "Unable to decode response signing key PEM"}
+	}
+
+	key, err := x509.ParseECPrivateKey(pemBlock.Bytes)
+	if err != nil {
+		return nil, certutil.InternalError{Err: fmt.Sprintf("Unable to parse response signing key: %s", err)}
+	}
+
+	return key, nil
+}
+
+// Produces a base64-encoded detached ECDSA-SHA256 signature over the given
+// DER certificate bytes
+func signCertificateBytes(req *logical.Request, certBytes []byte) (string, error) {
+	key, err := fetchResponseSigningKey(req)
+	if err != nil {
+		return "", err
+	}
+	if key == nil {
+		return "", certutil.UserError{Err: "No response signing key has been configured"}
+	}
+
+	digest := sha256.Sum256(certBytes)
+	signature, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return "", certutil.InternalError{Err: fmt.Sprintf("Error signing certificate: %s", err)}
+	}
+
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+func marshalResponseSigningPublicKey(key *ecdsa.PrivateKey) (string, error) {
+	pubBytes, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return "", fmt.Errorf("Error marshalling response signing public key: %s", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: pubBytes,
+	})), nil
+}
+
+func (b *backend) pathResponseSigningKeyWrite(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("Error generating response signing key: %s", err)
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("Error marshalling response signing key: %s", err)
+	}
+
+	entry, err := logical.StorageEntryJSON("config/response_signing_key", &responseSigningKeyEntry{
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "EC PRIVATE KEY",
+			Bytes: keyBytes,
+		})),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(entry); err != nil {
+		return nil, err
+	}
+
+	publicKey, err := marshalResponseSigningPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"public_key": publicKey,
+		},
+	}, nil
+}
+
+func (b *backend) pathResponseSigningKeyRead(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	key, err := fetchResponseSigningKey(req)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return logical.ErrorResponse("No response signing key has been configured"), nil
+	}
+
+	publicKey, err := marshalResponseSigningPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"public_key": publicKey,
+		},
+	}, nil
+}
+
+const pathConfigResponseSigningHelpSyn = `
+Generate the key used to sign issued certificates for response integrity.
+`
+
+const pathConfigResponseSigningHelpDesc = `
+Writing to this endpoint generates a new P-256 ECDSA key, replacing any
+existing one. When "sign_response" is set on an issue request, the
+response includes a detached ECDSA-SHA256 signature made with this key
+over the DER bytes of the issued certificate. The public key is returned,
+and can also be fetched from the "response_signing_key" endpoint.
+`
+
+const pathFetchResponseSigningKeyHelpSyn = `
+Fetch the public key used to verify signed issue responses.
+`
+
+const pathFetchResponseSigningKeyHelpDesc = `
+This returns the PEM-encoded public key that verifies the
+"certificate_signature" field of issue responses requested with
+"sign_response".
+`
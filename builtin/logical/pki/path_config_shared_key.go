@@ -0,0 +1,145 @@
+package pki
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/vault/helper/certutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const sharedKeyWarning = `Every certificate issued by a role in shared key mode carries the same key pair. Compromising any one holder of the key compromises every certificate issued by the role, and revoking one certificate does not protect the key.`
+
+// sharedKeyEntry holds an operator-provided private key that is used for
+// every certificate issued by a role in shared key mode
+type sharedKeyEntry struct {
+	PrivateKey string `json:"private_key" mapstructure:"private_key" structs:"private_key"`
+}
+
+func pathConfigSharedKey(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/shared_key/" + framework.GenericNameRegex("role"),
+		Fields: map[string]*framework.FieldSchema{
+			"role": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `The role that uses this key`,
+			},
+
+			"pem_key": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `PEM-format, unencrypted RSA or EC private key`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.WriteOperation:  b.pathSharedKeyWrite,
+			logical.DeleteOperation: b.pathSharedKeyDelete,
+		},
+
+		HelpSynopsis:    pathConfigSharedKeyHelpSyn,
+		HelpDescription: pathConfigSharedKeyHelpDesc,
+	}
+}
+
+// Fetches the shared key configured for the given role, returning nil if
+// none has been configured
+func fetchSharedKey(req *logical.Request, roleName string) (*certutil.ParsedCertBundle, error) {
+	entry, err := req.Storage.Get("config/shared_key/" + roleName)
+	if err != nil {
+		return nil, certutil.InternalError{Err: fmt.Sprintf("Unable to fetch shared key: %s", err)}
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var keyEntry sharedKeyEntry
+	if err := entry.DecodeJSON(&keyEntry); err != nil {
+		return nil, certutil.InternalError{Err: fmt.Sprintf("Unable to decode shared key: %s", err)}
+	}
+
+	parsedBundle, err := certutil.ParsePEMBundle(keyEntry.PrivateKey)
+	if err != nil {
+		return nil, certutil.InternalError{Err: fmt.Sprintf("Unable to parse shared key: %s", err)}
+	}
+
+	return parsedBundle, nil
+}
+
+func (b *backend) pathSharedKeyWrite(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("role").(string)
+	pemKey := data.Get("pem_key").(string)
+
+	parsedBundle, err := certutil.ParsePEMBundle(pemKey)
+	if err != nil {
+		switch err.(type) {
+		case certutil.InternalError:
+			return nil, err
+		default:
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	if parsedBundle.PrivateKey == nil {
+		return logical.ErrorResponse("No private key found in pem_key"), nil
+	}
+	if parsedBundle.Certificate != nil || parsedBundle.IssuingCA != nil {
+		return logical.ErrorResponse("Only a private key may be given in pem_key"), nil
+	}
+
+	// Issuance checks the key again, as the role may change afterwards
+	role, err := b.getRole(req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role != nil {
+		keyType, keyBits := publicKeyTypeBits(parsedBundle.PrivateKey.Public())
+		if err := checkCSRKey(role, keyType, keyBits); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if role.ECOnly {
+			if err := checkECOnlyKey(role, keyType, keyBits); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON("config/shared_key/"+roleName, &sharedKeyEntry{
+		PrivateKey: pemKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(entry); err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{}
+	resp.AddWarning(sharedKeyWarning)
+	return resp, nil
+}
+
+func (b *backend) pathSharedKeyDelete(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := req.Storage.Delete("config/shared_key/" + data.Get("role").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+const pathConfigSharedKeyHelpSyn = `
+Configure the private key shared by all certificates issued by a role.
+`
+
+const pathConfigSharedKeyHelpDesc = `
+This configures an operator-provided private key for the given role. When
+the role has "use_shared_key" set, certificates are issued against this
+key instead of a freshly generated one, and the key is not returned in
+issue responses. The key cannot be read back from this endpoint.
+
+This is intended only for fleets of devices that must all carry one key
+injected by the operator. Every certificate issued by the role shares the
+key, so compromising any one holder compromises all of them.
+`
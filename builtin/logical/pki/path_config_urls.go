@@ -0,0 +1,150 @@
+package pki
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/fatih/structs"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// urlEntries holds the URLs set in the Authority Information Access and
+// CRL Distribution Points extensions of issued certificates
+type urlEntries struct {
+	IssuingCertificates   []string `json:"issuing_certificates" mapstructure:"issuing_certificates" structs:"issuing_certificates"`
+	CRLDistributionPoints []string `json:"crl_distribution_points" mapstructure:"crl_distribution_points" structs:"crl_distribution_points"`
+	OCSPServers           []string `json:"ocsp_servers" mapstructure:"ocsp_servers" structs:"ocsp_servers"`
+}
+
+func pathConfigURLs(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/urls",
+		Fields: map[string]*framework.FieldSchema{
+			"issuing_certificates": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `Comma-delimited list of URLs to be used
+for the issuing certificate attribute`,
+			},
+
+			"crl_distribution_points": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `Comma-delimited list of URLs to be used
+for the CRL distribution points attribute`,
+			},
+
+			"ocsp_servers": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `Comma-delimited list of URLs to be used
+for the OCSP servers attribute`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:  b.pathURLsRead,
+			logical.WriteOperation: b.pathURLsWrite,
+		},
+
+		HelpSynopsis:    pathConfigURLsHelpSyn,
+		HelpDescription: pathConfigURLsHelpDesc,
+	}
+}
+
+// Returns the configured URLs, or nil if none have been written
+func getURLs(s logical.Storage) (*urlEntries, error) {
+	entry, err := s.Get("urls")
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result urlEntries
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// Splits a comma-delimited list of URLs, checking that each is an
+// absolute URL
+func parseURLList(field, urls string) ([]string, error) {
+	var result []string
+	if len(urls) == 0 {
+		return result, nil
+	}
+
+	for _, v := range strings.Split(urls, ",") {
+		v = strings.TrimSpace(v)
+		parsedURL, err := url.Parse(v)
+		if err != nil || len(parsedURL.Scheme) == 0 || len(parsedURL.Host) == 0 {
+			return nil, fmt.Errorf("The value '%s' in %s is not a valid URL", v, field)
+		}
+		result = append(result, v)
+	}
+
+	return result, nil
+}
+
+func (b *backend) pathURLsRead(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entries, err := getURLs(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if entries == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: structs.New(entries).Map(),
+	}, nil
+}
+
+func (b *backend) pathURLsWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	entries := &urlEntries{}
+
+	var err error
+	entries.IssuingCertificates, err = parseURLList("issuing_certificates", d.Get("issuing_certificates").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	entries.CRLDistributionPoints, err = parseURLList("crl_distribution_points", d.Get("crl_distribution_points").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	entries.OCSPServers, err = parseURLList("ocsp_servers", d.Get("ocsp_servers").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	entry, err := logical.StorageEntryJSON("urls", entries)
+	if err != nil {
+		return nil, err
+	}
+	err = req.Storage.Put(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+const pathConfigURLsHelpSyn = `
+Set the URLs for the issuing CA, CRL distribution points, and OCSP servers.
+`
+
+const pathConfigURLsHelpDesc = `
+This path allows you to set the issuing CA, CRL distribution points, and
+OCSP server URLs that will be encoded into issued certificates. If these
+values are not set, no such information will be encoded in the issued
+certificates, other than the CRL distribution points of the CA
+certificate, which are copied. To delete URLs, simply re-set the
+appropriate value with an empty string.
+
+Multiple URLs can be specified for each type; use commas to separate them.
+`
@@ -0,0 +1,88 @@
+package pki
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/hashicorp/vault/helper/certutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func pathCSRInfo(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "csr/info",
+		Fields: map[string]*framework.FieldSchema{
+			"csr": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `PEM-format certificate signing request`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.WriteOperation: b.pathCSRInfoWrite,
+		},
+
+		HelpSynopsis:    pathCSRInfoHelpSyn,
+		HelpDescription: pathCSRInfoHelpDesc,
+	}
+}
+
+// Parses a PEM-encoded CSR and checks its self-signature
+func parseCSR(pemCSR string) (*x509.CertificateRequest, error) {
+	pemBlock, _ := pem.Decode([]byte(pemCSR))
+	if pemBlock == nil || pemBlock.Type != "CERTIFICATE REQUEST" {
+		return nil, certutil.UserError{Err: "A PEM-format certificate signing request must be provided"}
+	}
+
+	csr, err := x509.ParseCertificateRequest(pemBlock.Bytes)
+	if err != nil {
+		return nil, certutil.UserError{Err: fmt.Sprintf("Unable to parse certificate signing request: %s", err)}
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, certutil.UserError{Err: fmt.Sprintf("Invalid certificate signing request signature: %s", err)}
+	}
+
+	return csr, nil
+}
+
+func (b *backend) pathCSRInfoWrite(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	csr, err := parseCSR(data.Get("csr").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	keyType, keyBits := publicKeyTypeBits(csr.PublicKey)
+	switch keyType {
+	case "rsa", "ec", "ed25519":
+	default:
+		return logical.ErrorResponse("Unsupported public key type in certificate signing request"), nil
+	}
+
+	publicKeyHash := sha256.Sum256(csr.RawSubjectPublicKeyInfo)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"subject":           csr.Subject.String(),
+			"common_name":       csr.Subject.CommonName,
+			"sans":              formatSANs(csr.DNSNames, csr.EmailAddresses, csr.IPAddresses, csr.URIs),
+			"key_type":          keyType,
+			"key_bits":          keyBits,
+			"public_key_sha256": certutil.GetOctalFormatted(publicKeyHash[:], ":"),
+		},
+	}, nil
+}
+
+const pathCSRInfoHelpSyn = `
+Inspect a certificate signing request without signing it.
+`
+
+const pathCSRInfoHelpDesc = `
+This endpoint parses the given PEM-format certificate signing request and
+checks its signature, then returns its subject, Subject Alternative
+Names, key type and size, and the SHA-256 hash of its DER-encoded public
+key. Nothing is signed or stored.
+`
@@ -1,6 +1,7 @@
 package pki
 
 import (
+	"encoding/base64"
 	"encoding/pem"
 	"fmt"
 
@@ -9,6 +10,15 @@ import (
 	"github.com/hashicorp/vault/logical/framework"
 )
 
+// The encoding of certificates and CRLs returned in the non-raw format
+var fetchEncodingSchema = &framework.FieldSchema{
+	Type:    framework.TypeString,
+	Default: "pem",
+	Description: `The encoding of the returned certificate or
+CRL: "pem", or "der" for base64-encoded DER.
+Defaults to "pem".`,
+}
+
 // Returns the CA in raw format
 func pathFetchCA(b *backend) *framework.Path {
 	return &framework.Path{
@@ -48,6 +58,7 @@ func pathFetchValid(b *backend) *framework.Path {
 				Description: `Certificate serial number, in colon- or
 hyphen-separated octal`,
 			},
+			"encoding": fetchEncodingSchema,
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -63,6 +74,9 @@ hyphen-separated octal`,
 func pathFetchCRLViaCertPath(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: `cert/crl`,
+		Fields: map[string]*framework.FieldSchema{
+			"encoding": fetchEncodingSchema,
+		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
 			logical.ReadOperation: b.pathFetchRead,
@@ -80,6 +94,7 @@ func (b *backend) pathFetchRead(req *logical.Request, data *framework.FieldData)
 	var certEntry *logical.StorageEntry
 	var funcErr error
 	var certificate []byte
+	var encoding string
 	response = &logical.Response{
 		Data: map[string]interface{}{},
 	}
@@ -95,6 +110,7 @@ func (b *backend) pathFetchRead(req *logical.Request, data *framework.FieldData)
 		contentType = "application/pkix-cert"
 		if req.Path == "ca/pem" {
 			pemType = "CERTIFICATE"
+			contentType = "application/pem-certificate-chain"
 		}
 	case req.Path == "crl" || req.Path == "crl/pem":
 		serial = "crl"
@@ -105,16 +121,33 @@ func (b *backend) pathFetchRead(req *logical.Request, data *framework.FieldData)
 	case req.Path == "cert/crl":
 		serial = "crl"
 		pemType = "X509 CRL"
+		encoding = data.Get("encoding").(string)
 	default:
 		serial = data.Get("serial").(string)
+		if serial != "ca" {
+			serial, funcErr = parseSerial(serial, "")
+			if funcErr != nil {
+				response = logical.ErrorResponse(funcErr.Error())
+				goto reply
+			}
+		}
 		pemType = "CERTIFICATE"
+		encoding = data.Get("encoding").(string)
+	}
+	switch encoding {
+	case "", "pem":
+	case "der":
+		pemType = ""
+	default:
+		response = logical.ErrorResponse(fmt.Sprintf("Unknown encoding: %s", encoding))
+		goto reply
 	}
 	if len(serial) == 0 {
 		response = logical.ErrorResponse("The serial number must be provided")
 		goto reply
 	}
 
-	_, funcErr = fetchCAInfo(req)
+	_, funcErr = b.fetchCAInfo(req)
 	switch funcErr.(type) {
 	case certutil.UserError:
 		response = logical.ErrorResponse(fmt.Sprintf("%s", funcErr))
@@ -142,6 +175,8 @@ func (b *backend) pathFetchRead(req *logical.Request, data *framework.FieldData)
 			Bytes: certEntry.Value,
 		}
 		certificate = pem.EncodeToMemory(&block)
+	} else if encoding == "der" {
+		certificate = []byte(base64.StdEncoding.EncodeToString(certEntry.Value))
 	}
 
 reply:
@@ -159,6 +194,7 @@ reply:
 		response.Data[logical.HTTPStatusCode] = 200
 	case retErr != nil:
 		response = nil
+	case response.IsError():
 	default:
 		response.Data["certificate"] = string(certificate)
 	}
@@ -173,5 +209,7 @@ Fetch a CA, CRL, or non-revoked certificate.
 const pathFetchHelpDesc = `
 This allows certificates to be fetched. If using the fetch/ prefix any non-revoked certificate can be fetched.
 
-Using "ca" or "crl" as the value fetches the appropriate information in DER encoding. Add "/pem" to either to get PEM encoding.
+Using "ca" or "crl" as the value fetches the appropriate information in DER encoding. Add "/pem" to either to get PEM encoding; the PEM CA certificate is served as "application/pem-certificate-chain" so that it can be imported into browsers and trust stores directly.
+
+Under the cert/ prefix, the certificate or CRL is returned in PEM encoding by default; set "encoding" to "der" to get base64-encoded DER instead.
 `
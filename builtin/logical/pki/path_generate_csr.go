@@ -0,0 +1,346 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fatih/structs"
+	"github.com/hashicorp/vault/helper/certutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// pendingCSREntry holds a generated private key until a certificate for it,
+// signed by an external CA, is installed
+type pendingCSREntry struct {
+	Role           string `json:"role" structs:"role" mapstructure:"role"`
+	CSR            string `json:"csr" structs:"csr" mapstructure:"csr"`
+	PrivateKeyType string `json:"private_key_type" structs:"private_key_type" mapstructure:"private_key_type"`
+	PrivateKey     string `json:"private_key" structs:"private_key" mapstructure:"private_key"`
+
+	// The format the key is returned in on installation; the stored key
+	// always keeps its native encoding
+	PrivateKeyFormat string `json:"private_key_format" structs:"private_key_format" mapstructure:"private_key_format"`
+
+	// When the key was generated; tidy removes entries that have been
+	// pending for too long
+	CreatedAt time.Time `json:"created_at" structs:"created_at" mapstructure:"created_at"`
+}
+
+func pathGenerateCSR(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "generate-csr/" + framework.GenericNameRegex("role"),
+		Fields: map[string]*framework.FieldSchema{
+			"role": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The desired role with configuration for this
+request`,
+			},
+			"common_name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `The requested common name`,
+			},
+			"alt_names": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The requested Subject Alternative Names, if any,
+in a comma-delimited list`,
+			},
+			"ip_sans": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The requested IP SANs, if any, in a
+comma-delimited list`,
+			},
+			"uri_sans": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The requested URI SANs, if any, in a
+comma-delimited list`,
+			},
+			"private_key_format": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "pkcs1",
+				Description: `The format of the private key returned by
+install-cert: "pkcs1", which keeps the native RSA
+or EC encoding, or "pkcs8". Defaults to "pkcs1".`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.WriteOperation: b.pathGenerateCSRWrite,
+		},
+
+		HelpSynopsis:    pathGenerateCSRHelpSyn,
+		HelpDescription: pathGenerateCSRHelpDesc,
+	}
+}
+
+func pathInstallCert(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "install-cert",
+		Fields: map[string]*framework.FieldSchema{
+			"handle": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `The handle returned by generate-csr`,
+			},
+			"certificate": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `The PEM-format certificate signed from the CSR`,
+			},
+			"issuing_ca": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The PEM-format certificate of the external CA,
+if it should be returned with the pair`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.WriteOperation: b.pathInstallCertWrite,
+		},
+
+		HelpSynopsis:    pathInstallCertHelpSyn,
+		HelpDescription: pathInstallCertHelpDesc,
+	}
+}
+
+func (b *backend) pathGenerateCSRWrite(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("role").(string)
+
+	cn := data.Get("common_name").(string)
+	if len(cn) == 0 {
+		return logical.ErrorResponse("The common_name field is required"), nil
+	}
+	commonNames := []string{cn}
+
+	privateKeyFormat := data.Get("private_key_format").(string)
+	switch privateKeyFormat {
+	case "pkcs1", "pkcs8":
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("Unknown private key format: %s", privateKeyFormat)), nil
+	}
+
+	cnAlt := data.Get("alt_names").(string)
+	if len(cnAlt) != 0 {
+		for _, v := range strings.Split(cnAlt, ",") {
+			commonNames = append(commonNames, v)
+		}
+	}
+
+	role, err := b.getRole(req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("Unknown role: %s", roleName)), nil
+	}
+
+	ipSANs, err := parseIPSANs(role, data.Get("ip_sans").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	uriSANs, err := parseURISANs(role, data.Get("uri_sans").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	badName, err := validateCommonNames(req, commonNames, role)
+	if len(badName) != 0 {
+		return logical.ErrorResponse(fmt.Sprintf("Name %s not allowed by this role", badName)), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Error validating name %s: %s", badName, err)
+	}
+
+	if len(role.DefaultSANs) != 0 {
+		commonNames, ipSANs, uriSANs, err = mergeDefaultSANs(role.DefaultSANs, commonNames, ipSANs, uriSANs)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	commonNames, err = applyMandatorySANSuffix(role, commonNames)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if err := checkSANLengths(role, commonNames, ipSANs, uriSANs, nil); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	keyBundle := &certutil.ParsedCertBundle{}
+	err = generatePrivateKey(role.KeyType, role.KeyBits, keyBundle)
+	switch err.(type) {
+	case certutil.UserError:
+		return logical.ErrorResponse(err.Error()), nil
+	case certutil.InternalError:
+		return nil, err
+	}
+
+	subject := pkix.Name{
+		CommonName: cn,
+	}
+	if len(role.DefaultOrganization) != 0 {
+		subject.Organization = []string{role.DefaultOrganization}
+	}
+	if len(role.DefaultOU) != 0 {
+		subject.OrganizationalUnit = []string{role.DefaultOU}
+	}
+	subject.Country = subjectValues(role.Country)
+	subject.Locality = subjectValues(role.Locality)
+	subject.Province = subjectValues(role.Province)
+	subject.PostalCode = subjectValues(role.PostalCode)
+
+	csrTemplate := &x509.CertificateRequest{
+		Subject:     subject,
+		DNSNames:    commonNames,
+		IPAddresses: ipSANs,
+		URIs:        uriSANs,
+	}
+	if keyBundle.PrivateKeyType == certutil.Ed25519PrivateKey {
+		csrTemplate.SignatureAlgorithm = x509.PureEd25519
+	}
+
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, csrTemplate, keyBundle.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create certificate signing request: %s", err)
+	}
+
+	keyCertBundle, err := keyBundle.ToCertBundle()
+	if err != nil {
+		return nil, fmt.Errorf("Error converting private key: %s", err)
+	}
+
+	handleBytes := make([]byte, 16)
+	if _, err := rand.Read(handleBytes); err != nil {
+		return nil, fmt.Errorf("Error generating handle: %s", err)
+	}
+	handle := hex.EncodeToString(handleBytes)
+
+	pending := &pendingCSREntry{
+		Role:             roleName,
+		CSR:              strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrBytes}))),
+		PrivateKeyType:   keyCertBundle.PrivateKeyType,
+		PrivateKey:       keyCertBundle.PrivateKey,
+		PrivateKeyFormat: privateKeyFormat,
+		CreatedAt:        b.clock(),
+	}
+	entry, err := logical.StorageEntryJSON("csr/pending/"+handle, pending)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(entry); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"handle": handle,
+			"csr":    pending.CSR,
+		},
+	}, nil
+}
+
+func (b *backend) pathInstallCertWrite(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	handle := data.Get("handle").(string)
+	if len(handle) == 0 {
+		return logical.ErrorResponse("The handle field is required"), nil
+	}
+
+	b.pendingCSRLock.Lock()
+	defer b.pendingCSRLock.Unlock()
+
+	entry, err := req.Storage.Get("csr/pending/" + handle)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse(fmt.Sprintf("No pending certificate signing request with handle %s", handle)), nil
+	}
+
+	var pending pendingCSREntry
+	if err := entry.DecodeJSON(&pending); err != nil {
+		return nil, fmt.Errorf("Unable to decode pending certificate signing request: %s", err)
+	}
+
+	bundle := &certutil.CertBundle{
+		PrivateKeyType: pending.PrivateKeyType,
+		PrivateKey:     pending.PrivateKey,
+		Certificate:    data.Get("certificate").(string),
+		IssuingCA:      data.Get("issuing_ca").(string),
+	}
+	if len(bundle.Certificate) == 0 {
+		return logical.ErrorResponse("The certificate field is required"), nil
+	}
+
+	parsedBundle, err := bundle.ToParsedCertBundle()
+	if err != nil {
+		switch err.(type) {
+		case certutil.InternalError:
+			return nil, err
+		default:
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	// The certificate must have been signed from this handle's CSR
+	certPublicKey, err := x509.MarshalPKIXPublicKey(parsedBundle.Certificate.PublicKey)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("Unable to read the certificate's public key: %s", err)), nil
+	}
+	storedPublicKey, err := x509.MarshalPKIXPublicKey(parsedBundle.PrivateKey.Public())
+	if err != nil {
+		return nil, fmt.Errorf("Unable to marshal the stored public key: %s", err)
+	}
+	if !bytes.Equal(certPublicKey, storedPublicKey) {
+		return logical.ErrorResponse("The certificate does not match the key generated for this handle"), nil
+	}
+
+	// Handles created before the format could be chosen have none set
+	if err := formatPrivateKey(bundle, parsedBundle.PrivateKey, pending.PrivateKeyFormat); err != nil {
+		return nil, err
+	}
+
+	// The key is handed out exactly once
+	if err := req.Storage.Delete("csr/pending/" + handle); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: structs.New(bundle).Map(),
+	}, nil
+}
+
+const pathGenerateCSRHelpSyn = `
+Generate a private key and a CSR for signing by an external CA.
+`
+
+const pathGenerateCSRHelpDesc = `
+This path generates a private key according to the given role and
+returns a certificate signing request for it, along with a handle. The
+names are checked against the role as for issuance, and the role's
+default SANs and subject values are included. The private key is kept by
+the backend and is not returned.
+
+Once an external CA has signed the request, write the certificate and the
+handle to "install-cert" to pair the certificate with the key. Keys that
+are not installed are removed by "tidy" once they are older than its
+"pending_csr_max_age".
+`
+
+const pathInstallCertHelpSyn = `
+Pair an externally-signed certificate with its generated key.
+`
+
+const pathInstallCertHelpDesc = `
+This path accepts a certificate signed from a CSR made by "generate-csr",
+checks that it matches the key generated for the handle, and returns the
+certificate together with the private key. The key is then removed from
+the backend, so each handle can be installed only once.
+`
@@ -1,8 +1,10 @@
 package pki
 
 import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
 	"fmt"
-	"net"
 	"strings"
 	"time"
 
@@ -36,11 +38,85 @@ in a comma-delimited list`,
 				Type: framework.TypeString,
 				Description: `The requested IP SANs, if any, in a
 common-delimited list`,
+			},
+			"uri_sans": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The requested URI SANs, if any, in a
+comma-delimited list`,
+			},
+			"other_sans": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The requested other SANs, if any, in a
+comma-delimited list of "oid;UTF8:value" entries,
+such as a user principal name`,
 			},
 			"lease": &framework.FieldSchema{
 				Type:        framework.TypeString,
 				Description: `The requested lease. DEPRECATED: use "ttl" instead.`,
 			},
+			"country": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `A comma-delimited list of two-letter country
+codes (C) to set in the subject, instead of the
+role's or CA's. Each must be one of the role's
+allowed countries.`,
+			},
+			"organization": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The organization (O) to set in the subject,
+instead of the CA's. Must be one of the role's
+allowed organizations.`,
+			},
+			"serial_number": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The serialNumber attribute to set in the
+subject, such as a device serial. This is not
+the certificate's own serial number, which the
+attribute holds by default.`,
+			},
+			"precertificate": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If set, a Certificate Transparency
+precertificate carrying the critical poison
+extension is issued instead of a regular
+certificate. The role must allow this.`,
+			},
+			"precertificate_serial": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The serial number of a precertificate issued by
+this role. If set, the final certificate is issued
+from it, with the SCTs given in "scts"; it keeps
+the precertificate's serial number, key, subject,
+SANs, validity, and extensions.`,
+			},
+			"scts": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `Base64-encoded signed certificate timestamps
+obtained from Certificate Transparency logs for the
+precertificate given in "precertificate_serial", in
+a comma-delimited list, to embed in the final
+certificate`,
+			},
+			"private_key_format": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "pkcs1",
+				Description: `The format of the private key in the
+response: "pkcs1", which keeps the native RSA or
+EC encoding, or "pkcs8". Defaults to "pkcs1".`,
+			},
+			"serial_format": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "hex_colon",
+				Description: `The format of the serial number in the
+response: "hex_colon", "hex", or "decimal".
+Defaults to "hex_colon".`,
+			},
+			"sign_response": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If set, the response includes a detached
+signature over the issued certificate, made with
+the backend's response signing key`,
+			},
 			"ttl": &framework.FieldSchema{
 				Type: framework.TypeString,
 				Description: `The requested Time To Live for the certificate;
@@ -60,19 +136,43 @@ be later than the role max TTL.`,
 	}
 }
 
+// precertificateInfo is stored for each issued precertificate, along with
+// the role that issued it
+type precertificateInfo struct {
+	CertificateBytes []byte `json:"certificate_bytes"`
+	Role             string `json:"role"`
+}
+
 func (b *backend) pathIssueCert(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.pathIssueSignCert(req, data, nil, time.Time{})
+}
+
+// Issues a certificate under the given role. If a CSR is given, the
+// certificate is issued for its public key instead of a generated one, and
+// the names in it are used unless others are requested explicitly; they
+// are checked against the role either way. When renewing, leaseIssued is
+// the time the lease was first issued, and the certificate may not outlive
+// the role's max_ttl counted from it.
+func (b *backend) pathIssueSignCert(
+	req *logical.Request, data *framework.FieldData, csr *x509.CertificateRequest, leaseIssued time.Time) (*logical.Response, error) {
 	roleName := data.Get("role").(string)
 
-	// Get the common name(s)
-	var commonNames []string
 	cn := data.Get("common_name").(string)
-	if len(cn) == 0 {
-		return logical.ErrorResponse("The common_name field is required"), nil
+	cnAlt := data.Get("alt_names").(string)
+	ipAlt := data.Get("ip_sans").(string)
+	uriAlt := data.Get("uri_sans").(string)
+	if csr != nil {
+		cn, cnAlt, ipAlt, uriAlt = csrNames(csr, cn, cnAlt, ipAlt, uriAlt)
+	}
+
+	// Get the common name(s). The common name comes first, unless it was
+	// left out, in which case only the alternative names are listed.
+	var commonNames []string
+	if len(cn) != 0 {
+		commonNames = []string{cn}
 	}
-	commonNames = []string{cn}
 
-	cnAlt := data.Get("alt_names").(string)
 	if len(cnAlt) != 0 {
 		for _, v := range strings.Split(cnAlt, ",") {
 			commonNames = append(commonNames, v)
@@ -88,23 +188,66 @@ func (b *backend) pathIssueCert(
 		return logical.ErrorResponse(fmt.Sprintf("Unknown role: %s", roleName)), nil
 	}
 
-	// Get any IP SANs
-	ipSANs := []net.IP{}
+	// Everything else comes from the precertificate, which has already
+	// been checked against the role
+	if csr == nil {
+		if len(data.Get("precertificate_serial").(string)) != 0 {
+			return b.pathIssueFromPrecertificate(req, data, role, roleName)
+		}
+		if len(data.Get("scts").(string)) != 0 {
+			return logical.ErrorResponse("Signed certificate timestamps can only be embedded along with a precertificate_serial"), nil
+		}
+	}
 
-	ipAlt := data.Get("ip_sans").(string)
-	if len(ipAlt) != 0 {
-		if !role.AllowIPSANs {
-			return logical.ErrorResponse(fmt.Sprintf("IP Subject Alternative Names are not allowed in this role, but was provided %s", ipAlt)), nil
-		}
-		for _, v := range strings.Split(ipAlt, ",") {
-			parsedIP := net.ParseIP(v)
-			if parsedIP == nil {
-				return logical.ErrorResponse(fmt.Sprintf("The value '%s' is not a valid IP address", v)), nil
-			}
-			ipSANs = append(ipSANs, parsedIP)
+	if len(cn) == 0 && !role.AllowEmptyCommonName {
+		return logical.ErrorResponse("The common_name field is required"), nil
+	}
+
+	// Refuse issuance outside of the role's issuance window
+	now := b.clock()
+	nextIssuance, err := nextIssuanceTime(role, now)
+	if err != nil {
+		return nil, err
+	}
+	if nextIssuance.After(now) {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"Issuance is outside of the role's issuance window; retry at %s",
+			nextIssuance.Format(time.RFC3339))), nil
+	}
+
+	// This is checked before any of the SAN handling, so that a role
+	// constraining the SANs cannot be used for an arbitrary CN
+	if role.RequireCNInAllowedDomains && len(cn) != 0 {
+		allowed, err := validateCNInAllowedDomains(req, cn, role)
+		if err != nil {
+			return nil, fmt.Errorf("Error validating common name %s: %s", cn, err)
+		}
+		if !allowed {
+			return logical.ErrorResponse(fmt.Sprintf("Common name %s is not within the allowed domains of this role", cn)), nil
 		}
 	}
 
+	// Get any IP SANs
+	ipSANs, err := parseIPSANs(role, ipAlt)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	uriSANs, err := parseURISANs(role, uriAlt)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	otherAlt := data.Get("other_sans").(string)
+	otherSANs, err := parseOtherSANs(role, otherAlt)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if len(cn) == 0 && len(commonNames) == 0 && len(ipSANs) == 0 && len(uriSANs) == 0 && len(otherSANs) == 0 {
+		return logical.ErrorResponse("At least one Subject Alternative Name is required when no common name is given"), nil
+	}
+
 	ttlField := data.Get("ttl").(string)
 	if len(ttlField) == 0 {
 		ttlField = data.Get("lease").(string)
@@ -137,11 +280,81 @@ func (b *backend) pathIssueCert(
 
 	if ttl > maxTTL {
 		// Don't error if they were using system defaults, only error if
-		// they specifically chose a bad TTL
-		if len(ttlField) == 0 {
+		// they specifically chose a bad TTL, unless the role clamps those
+		// as well
+		if len(ttlField) == 0 || role.TTLClamp {
 			ttl = maxTTL
 		} else {
-			return logical.ErrorResponse("TTL is larger than maximum allowed by this role"), nil
+			return logical.ErrorResponse(fmt.Sprintf(
+				"TTL of %s is larger than the maximum of %s allowed by role %s", ttl, maxTTL, roleName)), nil
+		}
+	}
+
+	if role.ServerFlag {
+		serverMaxTTLField := role.ServerMaxTTL
+		if len(serverMaxTTLField) == 0 {
+			serverMaxTTLField = defaultServerMaxTTL
+		}
+		serverMaxTTL, err := time.ParseDuration(serverMaxTTLField)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"Invalid server_max_ttl: %s", err)), nil
+		}
+		if ttl > serverMaxTTL {
+			if len(ttlField) == 0 || role.TTLClamp {
+				ttl = serverMaxTTL
+			} else {
+				return logical.ErrorResponse(fmt.Sprintf(
+					"TTL of %s is larger than the maximum of %s allowed for server certificates by role %s", ttl, serverMaxTTL, roleName)), nil
+			}
+		}
+	}
+
+	if len(role.TTLGranularity) != 0 {
+		granularity, err := time.ParseDuration(role.TTLGranularity)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"Invalid ttl_granularity: %s", err)), nil
+		}
+		if remainder := ttl % granularity; remainder != 0 {
+			// As with the maximum TTL, only error if they specifically
+			// chose a misaligned TTL
+			if len(ttlField) != 0 && !role.RoundTTLToGranularity {
+				return logical.ErrorResponse(fmt.Sprintf(
+					"TTL must be a multiple of %s for this role", granularity)), nil
+			}
+			ttl -= remainder
+			if ttl == 0 {
+				return logical.ErrorResponse(fmt.Sprintf(
+					"TTL must be at least %s for this role", granularity)), nil
+			}
+		}
+	}
+
+	// Renewal cannot extend a lease indefinitely
+	if !leaseIssued.IsZero() {
+		leaseEnd := leaseIssued.Add(maxTTL)
+		if !now.Before(leaseEnd) {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"Lease has reached the maximum TTL of %s allowed by role %s and cannot be renewed", maxTTL, roleName)), nil
+		}
+		if now.Add(ttl).After(leaseEnd) {
+			ttl = leaseEnd.Sub(now)
+		}
+	}
+
+	issuance, err := b.Issuance(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	// This has to happen before name validation, since a moved address
+	// is subject to the IP SAN rules instead
+	var ipCNWarning string
+	if len(cn) != 0 {
+		commonNames, ipSANs, ipCNWarning, err = handleIPCommonName(issuance.IPCommonNames, role, commonNames, ipSANs)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
 		}
 	}
 
@@ -152,7 +365,40 @@ func (b *backend) pathIssueCert(
 		return nil, fmt.Errorf("Error validating name %s: %s", badName, err)
 	}
 
-	signingBundle, caErr := fetchCAInfo(req)
+	// Default SANs are configured by the operator, so they are added
+	// after name validation
+	if len(role.DefaultSANs) != 0 {
+		commonNames, ipSANs, uriSANs, err = mergeDefaultSANs(role.DefaultSANs, commonNames, ipSANs, uriSANs)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	commonNames, err = applyMandatorySANSuffix(role, commonNames)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if err := checkSANLengths(role, commonNames, ipSANs, uriSANs, otherSANs); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// The common name is the first of the names; any repeats of it are
+	// dropped from the DNS SANs as well
+	if role.ExcludeCNFromSANs && len(cn) != 0 && len(ipSANs) == 0 && len(uriSANs) == 0 && len(otherSANs) == 0 {
+		hasDNSSAN := false
+		for i, v := range commonNames {
+			if i != 0 && v != commonNames[0] {
+				hasDNSSAN = true
+				break
+			}
+		}
+		if !hasDNSSAN {
+			return logical.ErrorResponse("At least one Subject Alternative Name is required when the common name is excluded from them"), nil
+		}
+	}
+
+	signingBundle, caErr := b.fetchCAInfo(req)
 	switch caErr.(type) {
 	case certutil.UserError:
 		return logical.ErrorResponse(fmt.Sprintf("Could not fetch the CA certificate: %s", caErr)), nil
@@ -160,30 +406,181 @@ func (b *backend) pathIssueCert(
 		return nil, fmt.Errorf("Error fetching CA certificate: %s", caErr)
 	}
 
-	if time.Now().Add(ttl).After(signingBundle.Certificate.NotAfter) {
+	urls, err := getURLs(req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching URL configuration: %s", err)
+	}
+
+	// Roles for separate environments may point at their own CRL and OCSP
+	// hosting; the issuing certificate URLs always come from the backend
+	if len(role.CRLDistributionPoints) != 0 || len(role.OCSPServers) != 0 {
+		roleURLs := &urlEntries{}
+		if urls != nil {
+			*roleURLs = *urls
+		}
+		if len(role.CRLDistributionPoints) != 0 {
+			roleURLs.CRLDistributionPoints, err = parseURLList("crl_distribution_points", role.CRLDistributionPoints)
+			if err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+		if len(role.OCSPServers) != 0 {
+			roleURLs.OCSPServers, err = parseURLList("ocsp_servers", role.OCSPServers)
+			if err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+		urls = roleURLs
+	}
+
+	var notBeforeDuration time.Duration
+	if len(role.NotBeforeDuration) != 0 {
+		notBeforeDuration, err = time.ParseDuration(role.NotBeforeDuration)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"Invalid not_before_duration: %s", err)), nil
+		}
+	}
+
+	if now.Add(ttl).After(signingBundle.Certificate.NotAfter) {
 		return logical.ErrorResponse(fmt.Sprintf("Cannot satisfy request, as TTL is beyond the expiration of the CA certificate")), nil
 	}
 
-	var usage certUsage
-	if role.ServerFlag {
-		usage = usage | serverUsage
+	organization := data.Get("organization").(string)
+	if len(organization) != 0 && !organizationAllowed(role, organization) {
+		return logical.ErrorResponse(fmt.Sprintf("Organization %s not allowed by this role", organization)), nil
 	}
-	if role.ClientFlag {
-		usage = usage | clientUsage
+	if len(organization) == 0 {
+		organization = role.DefaultOrganization
 	}
-	if role.CodeSigningFlag {
-		usage = usage | codeSigningUsage
+
+	country := subjectValues(role.Country)
+	if requested := data.Get("country").(string); len(requested) != 0 {
+		country = nil
+		for _, v := range subjectValues(strings.ToUpper(requested)) {
+			if !validCountryCode(v) {
+				return logical.ErrorResponse(fmt.Sprintf("Country %s is not an ISO 3166-1 alpha-2 code", v)), nil
+			}
+			if !countryAllowed(role, v) {
+				return logical.ErrorResponse(fmt.Sprintf("Country %s not allowed by this role", v)), nil
+			}
+			country = append(country, v)
+		}
+	}
+
+	serialFormat := data.Get("serial_format").(string)
+	switch serialFormat {
+	case "hex_colon", "hex", "decimal":
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("Unknown serial format: %s", serialFormat)), nil
+	}
+
+	// Signed certificates come without a private key
+	privateKeyFormat := "pkcs1"
+	if csr == nil {
+		privateKeyFormat = data.Get("private_key_format").(string)
+	}
+	switch privateKeyFormat {
+	case "pkcs1", "pkcs8":
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("Unknown private key format: %s", privateKeyFormat)), nil
+	}
+
+	precertificate := data.Get("precertificate").(bool)
+	if precertificate && !role.AllowPrecertificates {
+		return logical.ErrorResponse("Precertificates are not allowed by this role"), nil
+	}
+
+	var sharedKey *certutil.ParsedCertBundle
+	if role.UseSharedKey && csr == nil {
+		sharedKey, err = fetchSharedKey(req, roleName)
+		switch err.(type) {
+		case certutil.UserError:
+			return logical.ErrorResponse(err.Error()), nil
+		case certutil.InternalError:
+			return nil, err
+		}
+		if sharedKey == nil {
+			return logical.ErrorResponse(fmt.Sprintf("Role %s uses a shared key, but none has been configured", roleName)), nil
+		}
+	}
+
+	// Shared keys and CSRs come from outside of the role, so their keys
+	// have to be checked each time
+	keyType, keyBits := role.KeyType, role.KeyBits
+	switch {
+	case csr != nil:
+		keyType, keyBits = publicKeyTypeBits(csr.PublicKey)
+	case sharedKey != nil:
+		keyType, keyBits = publicKeyTypeBits(sharedKey.PrivateKey.Public())
+	}
+	if csr != nil || sharedKey != nil {
+		if err := checkCSRKey(role, keyType, keyBits); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+	if role.ECOnly {
+		if err := checkECOnlyKey(role, keyType, keyBits); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	usage, err := roleUsage(role)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	var keyUsage x509.KeyUsage
+	if len(role.KeyUsage) != 0 {
+		keyUsage, err = parseKeyUsages(role.KeyUsage)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	var policyIdentifiers []asn1.ObjectIdentifier
+	if len(role.PolicyIdentifiers) != 0 {
+		policyIdentifiers, err = parsePolicyIdentifiers(role.PolicyIdentifiers)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
 	}
 
 	creationBundle := &certCreationBundle{
-		SigningBundle: signingBundle,
-		CACert:        signingBundle.Certificate,
-		CommonNames:   commonNames,
-		IPSANs:        ipSANs,
-		KeyType:       role.KeyType,
-		KeyBits:       role.KeyBits,
-		TTL:           ttl,
-		Usage:         usage,
+		SigningBundle:         &signingBundle.ParsedCertBundle,
+		CACert:                signingBundle.Certificate,
+		CommonNames:           commonNames,
+		OmitCommonName:        len(cn) == 0,
+		ExcludeCNFromSANs:     role.ExcludeCNFromSANs,
+		OtherSANs:             otherSANs,
+		IPSANs:                ipSANs,
+		URISANs:               uriSANs,
+		Organization:          organization,
+		OrganizationalUnit:    role.DefaultOU,
+		Country:               country,
+		Locality:              subjectValues(role.Locality),
+		Province:              subjectValues(role.Province),
+		PostalCode:            subjectValues(role.PostalCode),
+		SubjectSerialNumber:   data.Get("serial_number").(string),
+		KeyType:               role.KeyType,
+		KeyBits:               role.KeyBits,
+		TTL:                   ttl,
+		NotBeforeDuration:     notBeforeDuration,
+		Usage:                 usage,
+		KeyUsage:              keyUsage,
+		PolicyIdentifiers:     policyIdentifiers,
+		Precertificate:        precertificate,
+		SharedKey:             sharedKey,
+		CSR:                   csr,
+		AuthorityKeyID:        signingBundle.SubjectKeyID,
+		OmitAuthorityKeyID:    role.OmitAuthorityKeyID,
+		Comment:               role.CertificateComment,
+		SignatureBits:         role.SignatureBits,
+		EnforceSubjectLengths: issuance.EnforceSubjectLengths,
+		URLs:                  urls,
+		SerialBits:            issuance.SerialBits,
+		FixedLengthSerial:     issuance.FixedLengthSerials,
+		Now:                   now,
 	}
 
 	parsedBundle, err := createCertificate(creationBundle)
@@ -199,13 +596,243 @@ func (b *backend) pathIssueCert(
 		return nil, fmt.Errorf("Error converting raw cert bundle to cert bundle: %s", err)
 	}
 
-	resp := b.Secret(SecretCertsType).Response(
-		structs.New(cb).Map(),
-		map[string]interface{}{
-			"serial_number": cb.SerialNumber,
+	if err := formatPrivateKey(cb, parsedBundle.PrivateKey, privateKeyFormat); err != nil {
+		return nil, err
+	}
+
+	respData, err := b.issuedCertData(req, data, signingBundle, parsedBundle, cb, serialFormat)
+	switch err.(type) {
+	case certutil.UserError:
+		return logical.ErrorResponse(err.Error()), nil
+	case certutil.InternalError:
+		return nil, err
+	}
+
+	// Precertificates are not certificates in their own right, so they
+	// get no lease and are never listed or revoked; they are only kept
+	// until the final certificate is issued from them
+	if precertificate {
+		entry, err := logical.StorageEntryJSON("precerts/"+cb.SerialNumber, &precertificateInfo{
+			CertificateBytes: parsedBundle.CertificateBytes,
+			Role:             roleName,
 		})
+		if err != nil {
+			return nil, fmt.Errorf("Error creating precertificate entry")
+		}
+		if err := req.Storage.Put(entry); err != nil {
+			return nil, fmt.Errorf("Unable to store precertificate locally")
+		}
+
+		resp := &logical.Response{
+			Data: respData,
+		}
+		if len(ipCNWarning) != 0 {
+			resp.AddWarning(ipCNWarning)
+		}
+		return resp, nil
+	}
+
+	// The original request is kept with the lease so that renewal can
+	// re-issue the certificate under the role's current policy
+	internalData := map[string]interface{}{
+		"serial_number": cb.SerialNumber,
+		"role":          roleName,
+		"common_name":   cn,
+		"alt_names":     cnAlt,
+		"ip_sans":       ipAlt,
+		"uri_sans":      uriAlt,
+		"other_sans":    otherAlt,
+		"organization":  data.Get("organization").(string),
+		"country":       data.Get("country").(string),
+
+		// Kept apart from the certificate's own serial number above
+		"subject_serial_number": data.Get("serial_number").(string),
+	}
+	if csr != nil {
+		internalData["csr"] = data.Get("csr").(string)
+	} else {
+		internalData["private_key_format"] = privateKeyFormat
+	}
+	resp := b.Secret(SecretCertsType).Response(respData, internalData)
 
 	resp.Secret.TTL = ttl
+	if len(ipCNWarning) != 0 {
+		resp.AddWarning(ipCNWarning)
+	}
+
+	err = req.Storage.Put(&logical.StorageEntry{
+		Key:   "certs/" + cb.SerialNumber,
+		Value: parsedBundle.CertificateBytes,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to store certificate locally")
+	}
+
+	// The new certificate is stored first, so that the identity is never
+	// left without a valid one
+	if role.SingleCertPerCN && len(cn) != 0 {
+		revokeResp, err := revokeCertsByCN(b, req, cn, cb.SerialNumber)
+		if err != nil || revokeResp != nil {
+			return revokeResp, err
+		}
+	}
+
+	b.notify(req, "issue", parsedBundle.Certificate, roleName)
+
+	return resp, nil
+}
+
+// Returns the response data for an issued certificate. Errors are either
+// certutil.UserError or certutil.InternalError.
+func (b *backend) issuedCertData(req *logical.Request, data *framework.FieldData,
+	signingBundle *caInfoBundle, parsedBundle *certutil.ParsedCertBundle,
+	cb *certutil.CertBundle, serialFormat string) (map[string]interface{}, error) {
+	respData := structs.New(cb).Map()
+
+	// Report exactly what was certified, after all policy has been applied
+	respData["subject"] = parsedBundle.Certificate.Subject.String()
+	respData["sans"] = certificateSANs(parsedBundle.Certificate)
+
+	respData["expiration"] = parsedBundle.Certificate.NotAfter.Unix()
+	respData["expiration_rfc3339"] = parsedBundle.Certificate.NotAfter.UTC().Format(time.RFC3339)
+
+	// Lets clients know whether they still need a root to build the chain
+	respData["issuer_is_root"] = isSelfSigned(signingBundle.Certificate)
+
+	chain, err := caChain(signingBundle)
+	if err != nil {
+		return nil, err
+	}
+	respData["ca_chain"] = chain
+
+	// Storage and the lease always use the canonical form
+	respData["serial_number"], err = formatSerial(cb.SerialNumber, serialFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	// The decimal value of the serial, as the CRL lists it, so that
+	// clients can match revocations without converting formats
+	respData["serial_number_raw"] = parsedBundle.Certificate.SerialNumber.String()
+
+	// Servers such as HAProxy want the key, certificate, and chain in a
+	// single file, in that order. When no private key is returned, only
+	// the certificate and chain are included.
+	combined := append([]string{cb.Certificate}, chain...)
+	if len(cb.PrivateKey) != 0 {
+		combined = append([]string{cb.PrivateKey}, combined...)
+	}
+	respData["combined_pem"] = strings.Join(combined, "\n") + "\n"
+
+	if data.Get("sign_response").(bool) {
+		respData["certificate_signature"], err = signCertificateBytes(req, parsedBundle.CertificateBytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return respData, nil
+}
+
+// Issues the final certificate for a stored precertificate. Logs sign the
+// precertificate's TBSCertificate, so the certificate is issued from it
+// unchanged, other than for the embedded SCTs; the precertificate is then
+// removed, so that no other certificate is issued with its serial number.
+func (b *backend) pathIssueFromPrecertificate(
+	req *logical.Request, data *framework.FieldData, role *roleEntry, roleName string) (*logical.Response, error) {
+	if !role.AllowPrecertificates {
+		return logical.ErrorResponse("Precertificates are not allowed by this role"), nil
+	}
+
+	serial, err := parseSerial(data.Get("precertificate_serial").(string), "")
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	serialFormat := data.Get("serial_format").(string)
+	switch serialFormat {
+	case "hex_colon", "hex", "decimal":
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("Unknown serial format: %s", serialFormat)), nil
+	}
+
+	sctsField := data.Get("scts").(string)
+	if len(sctsField) == 0 {
+		return logical.ErrorResponse("At least one signed certificate timestamp is required"), nil
+	}
+	var scts [][]byte
+	for _, v := range strings.Split(sctsField, ",") {
+		sct, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("The value '%s' is not a valid base64-encoded SCT: %s", v, err)), nil
+		}
+		scts = append(scts, sct)
+	}
+
+	entry, err := req.Storage.Get("precerts/" + serial)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching precertificate with serial %s: %s", serial, err)
+	}
+	if entry == nil {
+		return logical.ErrorResponse(fmt.Sprintf("No precertificate with serial %s", serial)), nil
+	}
+
+	var precertInfo precertificateInfo
+	if err := entry.DecodeJSON(&precertInfo); err != nil {
+		return nil, fmt.Errorf("Error decoding precertificate entry for serial %s: %s", serial, err)
+	}
+	if precertInfo.Role != roleName {
+		return logical.ErrorResponse(fmt.Sprintf("Precertificate with serial %s was not issued by role %s", serial, roleName)), nil
+	}
+
+	precert, err := x509.ParseCertificate(precertInfo.CertificateBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse stored precertificate with serial %s: %s", serial, err)
+	}
+	if b.clock().After(precert.NotAfter) {
+		return logical.ErrorResponse(fmt.Sprintf("Precertificate with serial %s has expired", serial)), nil
+	}
+
+	signingBundle, caErr := b.fetchCAInfo(req)
+	switch caErr.(type) {
+	case certutil.UserError:
+		return logical.ErrorResponse(fmt.Sprintf("Could not fetch the CA certificate: %s", caErr)), nil
+	case certutil.InternalError:
+		return nil, fmt.Errorf("Error fetching CA certificate: %s", caErr)
+	}
+
+	// SCTs are bound to the key of the issuer
+	if err := signingBundle.Certificate.CheckSignature(precert.SignatureAlgorithm, precert.RawTBSCertificate, precert.Signature); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("Precertificate with serial %s was not issued by the current CA certificate", serial)), nil
+	}
+
+	parsedBundle, err := createCertificateFromPrecertificate(signingBundle, precert, scts)
+	switch err.(type) {
+	case certutil.UserError:
+		return logical.ErrorResponse(err.Error()), nil
+	case certutil.InternalError:
+		return nil, err
+	}
+
+	cb, err := parsedBundle.ToCertBundle()
+	if err != nil {
+		return nil, fmt.Errorf("Error converting raw cert bundle to cert bundle: %s", err)
+	}
+
+	respData, err := b.issuedCertData(req, data, signingBundle, parsedBundle, cb, serialFormat)
+	switch err.(type) {
+	case certutil.UserError:
+		return logical.ErrorResponse(err.Error()), nil
+	case certutil.InternalError:
+		return nil, err
+	}
+
+	// Renewal would issue a new certificate, which the SCTs do not cover
+	resp := b.Secret(SecretCertsType).Response(respData, map[string]interface{}{
+		"serial_number": cb.SerialNumber,
+	})
+	resp.Secret.TTL = precert.NotAfter.Sub(b.clock())
+	resp.Secret.Renewable = false
 
 	err = req.Storage.Put(&logical.StorageEntry{
 		Key:   "certs/" + cb.SerialNumber,
@@ -215,6 +842,19 @@ func (b *backend) pathIssueCert(
 		return nil, fmt.Errorf("Unable to store certificate locally")
 	}
 
+	if err := req.Storage.Delete("precerts/" + serial); err != nil {
+		return nil, fmt.Errorf("Error deleting precertificate with serial %s: %s", serial, err)
+	}
+
+	if cn := precert.Subject.CommonName; role.SingleCertPerCN && len(cn) != 0 {
+		revokeResp, err := revokeCertsByCN(b, req, cn, cb.SerialNumber)
+		if err != nil || revokeResp != nil {
+			return revokeResp, err
+		}
+	}
+
+	b.notify(req, "issue", parsedBundle.Certificate, roleName)
+
 	return resp, nil
 }
 
@@ -1,7 +1,9 @@
 package pki
 
 import (
+	"crypto/x509"
 	"fmt"
+	"time"
 
 	"github.com/hashicorp/vault/helper/certutil"
 	"github.com/hashicorp/vault/logical"
@@ -17,6 +19,12 @@ func pathRevoke(b *backend) *framework.Path {
 				Description: `Certificate serial number, in colon- or
 hyphen-separated octal`,
 			},
+			"serial_format": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The format of the given serial number:
+"hex_colon", "hex", or "decimal". If not set,
+separated and plain hex are accepted.`,
+			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -33,7 +41,8 @@ func pathRotateCRL(b *backend) *framework.Path {
 		Pattern: `crl/rotate`,
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.ReadOperation: b.pathRotateCRLRead,
+			logical.ReadOperation:  b.pathRotateCRL,
+			logical.WriteOperation: b.pathRotateCRL,
 		},
 
 		HelpSynopsis:    pathRotateCRLHelpSyn,
@@ -47,13 +56,42 @@ func (b *backend) pathRevokeWrite(req *logical.Request, data *framework.FieldDat
 		return logical.ErrorResponse("The serial number must be provided"), nil
 	}
 
+	serial, err := parseSerial(serial, data.Get("serial_format").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// The revoked/ entry and the CRL are updated together, so concurrent
+	// revocations must not interleave
 	b.revokeStorageLock.Lock()
 	defer b.revokeStorageLock.Unlock()
 
+	// Unlike lease revocation, which must be idempotent, an explicit
+	// request to revoke an unknown or already-revoked certificate is an
+	// error. This is checked under the lock so concurrent requests for the
+	// same serial see each other's results.
+	revokedEntry, err := req.Storage.Get("revoked/" + serial)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching revocation info: %s", err)
+	}
+	certEntry, err := req.Storage.Get("certs/" + serial)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching certificate: %s", err)
+	}
+	switch {
+	case revokedEntry != nil && certEntry == nil:
+		return logical.ErrorResponse(fmt.Sprintf("Certificate with serial number %s is already revoked", serial)), nil
+	case revokedEntry == nil && certEntry == nil:
+		return logical.ErrorResponse(fmt.Sprintf("Certificate with serial number %s not found", serial)), nil
+	}
+
+	// If both exist, an earlier revocation was interrupted and is finished
+	// here
 	return revokeCert(b, req, serial)
 }
 
-func (b *backend) pathRotateCRLRead(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+func (b *backend) pathRotateCRL(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	// Taken so that the CRL is not rebuilt from a half-finished revocation
 	b.revokeStorageLock.Lock()
 	defer b.revokeStorageLock.Unlock()
 
@@ -63,13 +101,27 @@ func (b *backend) pathRotateCRLRead(req *logical.Request, data *framework.FieldD
 		return logical.ErrorResponse(fmt.Sprintf("Error during CRL building: %s", crlErr)), nil
 	case certutil.InternalError:
 		return nil, fmt.Errorf("Error encountered during CRL building: %s", crlErr)
-	default:
-		return &logical.Response{
-			Data: map[string]interface{}{
-				"success": true,
-			},
-		}, nil
 	}
+
+	// Report when the new CRL has to be rotated again
+	crlEntry, err := req.Storage.Get("crl")
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching the new CRL: %s", err)
+	}
+	if crlEntry == nil {
+		return nil, fmt.Errorf("The new CRL was not stored")
+	}
+	crl, err := x509.ParseRevocationList(crlEntry.Value)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing the new CRL: %s", err)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"success":     true,
+			"next_update": crl.NextUpdate.UTC().Format(time.RFC3339),
+		},
+	}, nil
 }
 
 const pathRevokeHelpSyn = `
@@ -78,6 +130,7 @@ Revoke a certificate by serial number.
 
 const pathRevokeHelpDesc = `
 This allows certificates to be revoked using its serial number. A root token is required.
+Revoking a certificate that is unknown or already revoked is an error.
 `
 
 const pathRotateCRLHelpSyn = `
@@ -86,4 +139,6 @@ Force a rebuild of the CRL.
 
 const pathRotateCRLHelpDesc = `
 Force a rebuild of the CRL. This can be used to remove expired certificates from it if no certificates have been revoked. A root token is required.
+
+Either a read or a write rebuilds the CRL, so that it can be refreshed on a schedule, such as from cron, before it expires. The CRL is valid for the expiry set in config/crl, and the response holds its next update time.
 `
@@ -2,6 +2,8 @@ package pki
 
 import (
 	"fmt"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/fatih/structs"
@@ -9,6 +11,22 @@ import (
 	"github.com/hashicorp/vault/logical/framework"
 )
 
+func pathListRoles(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/?$",
+
+		// Reads are accepted as well, since not every client can issue a
+		// list request
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathRoleList,
+			logical.ReadOperation: b.pathRoleList,
+		},
+
+		HelpSynopsis:    pathListRolesHelpSyn,
+		HelpDescription: pathListRolesHelpDesc,
+	}
+}
+
 func pathRoles(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: "roles/" + framework.GenericNameRegex("name"),
@@ -49,6 +67,46 @@ the value of max_ttl.`,
 				Description: "The maximum allowed lease duration",
 			},
 
+			"server_max_ttl": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: defaultServerMaxTTL,
+				Description: `The maximum allowed lease duration for
+certificates flagged for server use. Defaults
+to the CA/Browser Forum limit of 398 days.`,
+			},
+
+			"ttl_clamp": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, requested TTLs beyond max_ttl or
+server_max_ttl are capped to the maximum instead
+of rejected, as TTLs from defaults are.`,
+			},
+
+			"ttl_granularity": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `If set, issued certificate TTLs must be a
+whole multiple of this duration, such as "1h" or
+"24h". Defaults to no constraint.`,
+			},
+
+			"round_ttl_to_granularity": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, requested TTLs that are not a multiple
+of ttl_granularity are rounded down instead of
+rejected.`,
+			},
+
+			"not_before_duration": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "30s",
+				Description: `How far before the time of issuance the
+validity of certificates starts, to allow for
+clients whose clocks are behind; defaults to 30s`,
+			},
+
 			"allow_localhost": &framework.FieldSchema{
 				Type:    framework.TypeBool,
 				Default: true,
@@ -59,12 +117,28 @@ name in a request`,
 			"allowed_base_domain": &framework.FieldSchema{
 				Type:    framework.TypeString,
 				Default: "",
-				Description: `If set, clients can request certificates for
-subdomains directly beneath this base domain, including
-the wildcard subdomain. See the documentation for more
+				Description: `A single allowed domain. DEPRECATED: use
+"allowed_domains" instead.`,
+			},
+
+			"allowed_domains": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `A comma-separated list of domains; if set,
+clients can request certificates for subdomains
+directly beneath any of them, including the wildcard
+subdomain. See the documentation for more
 information.`,
 			},
 
+			"allow_glob_domains": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, entries of allowed_domains containing
+"*" are matched as glob patterns against the
+whole name, such as "app-*.example.com"`,
+			},
+
 			"allow_token_displayname": &framework.FieldSchema{
 				Type:    framework.TypeBool,
 				Default: false,
@@ -82,6 +156,14 @@ including wildcard subdomains. See the documentation for
 more information.`,
 			},
 
+			"allow_wildcard_certificates": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: true,
+				Description: `If false, names beginning with "*." are
+rejected, regardless of the other role options;
+defaults to true`,
+			},
+
 			"allow_any_name": &framework.FieldSchema{
 				Type:    framework.TypeBool,
 				Default: false,
@@ -90,11 +172,41 @@ any CN they like. See the documentation for more
 information.`,
 			},
 
+			"allow_empty_common_name": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, the common name may be left out, in
+which case the certificate is issued with an
+empty subject CN as long as at least one
+Subject Alternative Name is requested.
+Defaults to false.`,
+			},
+
+			"require_cn_in_allowed_domains": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, the CN must always match the allowed
+domains, even when allow_any_name,
+allow_token_displayname, or allow_localhost would
+permit it; these still apply to the SANs.
+Defaults to false.`,
+			},
+
+			"exclude_cn_from_sans": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, the common name is only set in the
+subject and not also added as a DNS SAN. At
+least one other Subject Alternative Name is then
+required. Defaults to false.`,
+			},
+
 			"enforce_hostnames": &framework.FieldSchema{
 				Type:    framework.TypeBool,
 				Default: false,
 				Description: `If set, only valid host names are allowed for
-CN and SANs.`,
+CN and SANs. A single leading wildcard label,
+as in "*.example.com", is allowed.`,
 			},
 
 			"allow_ip_sans": &framework.FieldSchema{
@@ -104,6 +216,208 @@ CN and SANs.`,
 Any valid IP is accepted.`,
 			},
 
+			"allowed_ip_addresses": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `If set, a comma-delimited list of the only IP
+addresses allowed as IP SANs. Ranges are not
+supported; each address must match exactly.`,
+			},
+
+			"allowed_ip_sans": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `If set, a comma-delimited list of CIDR blocks,
+such as "10.0.0.0/8", that IP SANs must fall
+within. Combined with allowed_ip_addresses,
+an address matching either is allowed.`,
+			},
+
+			"allow_uri_sans": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, URI Subject Alternative Names, such as
+SPIFFE IDs, are allowed; see allowed_uri_sans`,
+			},
+
+			"allowed_uri_sans": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `A comma-delimited list of the URI SANs that may
+be requested, in which "*" matches any sequence of
+characters. If empty, any URI is allowed when
+allow_uri_sans is set.`,
+			},
+
+			"allowed_other_sans": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `A comma-delimited list of the other SANs that
+may be requested, as "oid;UTF8:value" entries in
+which "*" in the value matches any sequence of
+characters, or "*" to allow any. If empty, no other
+SANs are allowed.`,
+			},
+
+			"default_sans": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `A comma-delimited list of DNS names, IP
+addresses, and URIs that are added to the Subject
+Alternative Names of every certificate issued by
+this role. These are not subject to the other name
+checks of the role.`,
+			},
+
+			"mandatory_san_suffix": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `If set, every certificate must include a DNS
+SAN ending in this domain, such as "mesh.local";
+see mandatory_san_action`,
+			},
+
+			"mandatory_san_action": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "append",
+				Description: `What to do when no requested name ends in the
+mandatory_san_suffix: "append" adds one derived
+from the first label of the common name, and
+"reject" fails the request. Defaults to "append".`,
+			},
+
+			"max_dns_name_length": &framework.FieldSchema{
+				Type:    framework.TypeInt,
+				Default: 0,
+				Description: `If set, the maximum length in characters of
+each DNS name in the certificate, including the
+common name and default SANs; 0 means no limit`,
+			},
+
+			"max_san_length": &framework.FieldSchema{
+				Type:    framework.TypeInt,
+				Default: 0,
+				Description: `If set, the maximum length in bytes of the
+DER-encoded Subject Alternative Name extension
+value; 0 means no limit`,
+			},
+
+			"allowed_organizations": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `A comma-delimited list of organizations that
+may be requested with the "organization" parameter
+when issuing. If empty, the organization cannot be
+overridden and is taken from the CA certificate.`,
+			},
+
+			"default_organization": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `The organization (O) to use in the subject
+when the request does not give one. If empty, the
+CA's organization is used.`,
+			},
+
+			"default_ou": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `The organizational unit (OU) to use in the
+subject. If empty, the CA's organizational unit is
+used.`,
+			},
+
+			"country": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `A comma-delimited list of two-letter country
+codes (C) to use in the subject. If empty, the
+CA's country is used.`,
+			},
+
+			"allowed_countries": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `A comma-delimited list of two-letter country
+codes that may be used in the subject, either in the
+"country" role setting or requested with the
+"country" parameter when issuing. If empty, the
+country cannot be requested.`,
+			},
+
+			"locality": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `A comma-delimited list of localities (L) to
+use in the subject. If empty, the CA's locality
+is used.`,
+			},
+
+			"province": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `A comma-delimited list of provinces or states
+(ST) to use in the subject. If empty, the CA's
+province is used.`,
+			},
+
+			"postal_code": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `A comma-delimited list of postal codes to use
+in the subject. If empty, the CA's postal code is
+used.`,
+			},
+
+			"single_cert_per_cn": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, issuing a certificate revokes any
+unexpired certificates previously issued by this
+backend with the same common name.`,
+			},
+
+			"use_shared_key": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, certificates are not issued with a
+freshly generated key but with the operator-provided
+key configured at "config/shared_key/<role>", and
+every certificate issued by this role shares that
+key. This is dangerous: compromising one holder of
+the key compromises all of them. Only use this
+when the key must be injected into a fleet of
+devices by the operator.`,
+			},
+
+			"omit_authority_key_id": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, issued certificates do not carry the
+authority key identifier extension. This is only
+for interoperability with validators that cannot
+handle it, as it makes chain building harder.`,
+			},
+
+			"certificate_comment": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `If set, issued certificates carry this ASCII
+text in the legacy Netscape Comment extension,
+which some device and browser UIs display`,
+			},
+
+			"crl_distribution_points": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `If set, a comma-delimited list of URLs for the
+CRL distribution points of issued certificates,
+instead of those set in config/urls`,
+			},
+
+			"ocsp_servers": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `If set, a comma-delimited list of URLs for the
+OCSP servers of issued certificates, instead of
+those set in config/urls`,
+			},
+
 			"server_flag": &framework.FieldSchema{
 				Type:    framework.TypeBool,
 				Default: true,
@@ -125,11 +439,117 @@ Defaults to true.`,
 use. Defaults to false.`,
 			},
 
+			"required_ext_key_usage": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `A comma-delimited list of extended key usages
+that are always included in issued certificates,
+in addition to those set by the usage flags. Valid
+values are "server_auth", "client_auth",
+"code_signing", "email_protection",
+"time_stamping", and "ocsp_signing".`,
+			},
+
+			"key_usage": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `A comma-delimited list of key usages that
+replaces the default of DigitalSignature,
+KeyEncipherment, and KeyAgreement. Valid values are
+"DigitalSignature", "ContentCommitment",
+"KeyEncipherment", "DataEncipherment",
+"KeyAgreement", "CertSign", "CRLSign",
+"EncipherOnly", and "DecipherOnly".`,
+			},
+
+			"policy_identifiers": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `A comma-delimited list of certificate policy
+OIDs in dotted-decimal form, such as
+"2.23.140.1.2.1", to set in the certificate
+policies extension of issued certificates`,
+			},
+
+			"allow_precertificates": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, clients can request Certificate
+Transparency precertificates carrying the critical
+poison extension, and then have the final
+certificates issued from them with embedded SCTs.`,
+			},
+
 			"key_type": &framework.FieldSchema{
 				Type:    framework.TypeString,
 				Default: "rsa",
-				Description: `The type of key to use; defaults to RSA. "rsa"
-and "ec" are the only valid values.`,
+				Description: `The type of key to use; defaults to RSA. "rsa",
+"ec", and "ed25519" are the only valid values.`,
+			},
+
+			"signature_bits": &framework.FieldSchema{
+				Type:    framework.TypeInt,
+				Default: 256,
+				Description: `The size of the hash used by the CA to sign
+issued certificates: 256, 384, or 512. Defaults
+to 256.`,
+			},
+
+			"ec_only": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, only certificates for EC keys are
+issued by this role, whatever the source of the
+key. The key type must then be "ec".`,
+			},
+
+			"allowed_ec_curves": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `A comma-delimited list of the curves allowed
+when "ec_only" is set: "P-224", "P-256", "P-384",
+and "P-521". If empty, all of them are allowed.`,
+			},
+
+			"allowed_key_types": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `A comma-delimited list of the key types allowed
+in CSRs signed with this role and in its shared
+key: "rsa", "ec", and "ed25519". If empty, all of
+them are allowed.`,
+			},
+
+			"min_rsa_key_bits": &framework.FieldSchema{
+				Type:    framework.TypeInt,
+				Default: 2048,
+				Description: `The minimum size of RSA keys in CSRs signed
+with this role and of its shared key; defaults
+to 2048`,
+			},
+
+			"min_ec_key_bits": &framework.FieldSchema{
+				Type:    framework.TypeInt,
+				Default: 256,
+				Description: `The minimum curve size of EC keys in CSRs
+signed with this role and of its shared key;
+defaults to 256`,
+			},
+
+			"issuance_window": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `If set, certificates are only issued during
+this daily window, given in UTC as "HH:MM-HH:MM".
+The window may cross midnight.`,
+			},
+
+			"issuance_days": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `If set, a comma-delimited list of the days,
+such as "mon,tue,wed,thu,fri", on which the
+issuance window opens; defaults to every day`,
 			},
 
 			"key_bits": &framework.FieldSchema{
@@ -137,7 +557,9 @@ and "ec" are the only valid values.`,
 				Default: 2048,
 				Description: `The number of bits to use. You will almost
 certainly want to change this if you adjust
-the key_type.`,
+the key_type. RSA keys may be 2048, 3072, 4096,
+or 8192 bits and EC keys 224, 256, 384, or 521
+bits. Ignored for "ed25519" keys.`,
 			},
 		},
 
@@ -168,6 +590,19 @@ func (b *backend) getRole(s logical.Storage, n string) (*roleEntry, error) {
 
 	// Migrate existing saved entries and save back if changed
 	modified := false
+	if !result.AllowWildcardCertificates {
+		// Roles saved before the option existed always allowed wildcards
+		var stored struct {
+			AllowWildcardCertificates *bool `json:"allow_wildcard_certificates"`
+		}
+		if err := entry.DecodeJSON(&stored); err != nil {
+			return nil, err
+		}
+		if stored.AllowWildcardCertificates == nil {
+			result.AllowWildcardCertificates = true
+			modified = true
+		}
+	}
 	if len(result.TTL) == 0 && len(result.Lease) != 0 {
 		result.TTL = result.Lease
 		result.Lease = ""
@@ -178,6 +613,11 @@ func (b *backend) getRole(s logical.Storage, n string) (*roleEntry, error) {
 		result.LeaseMax = ""
 		modified = true
 	}
+	if len(result.AllowedDomains) == 0 && len(result.AllowedBaseDomain) != 0 {
+		result.AllowedDomains = []string{result.AllowedBaseDomain}
+		result.AllowedBaseDomain = ""
+		modified = true
+	}
 	if modified {
 		jsonEntry, err := logical.StorageEntryJSON("role/"+n, &result)
 		if err != nil {
@@ -201,6 +641,16 @@ func (b *backend) pathRoleDelete(
 	return nil, nil
 }
 
+func (b *backend) pathRoleList(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List("role/")
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
 func (b *backend) pathRoleRead(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	role, err := b.getRole(req.Storage, data.Get("name").(string))
@@ -237,20 +687,70 @@ func (b *backend) pathRoleCreate(
 	name := data.Get("name").(string)
 
 	entry := &roleEntry{
-		MaxTTL:                data.Get("max_ttl").(string),
-		TTL:                   data.Get("ttl").(string),
-		AllowLocalhost:        data.Get("allow_localhost").(bool),
-		AllowedBaseDomain:     data.Get("allowed_base_domain").(string),
-		AllowTokenDisplayName: data.Get("allow_token_displayname").(bool),
-		AllowSubdomains:       data.Get("allow_subdomains").(bool),
-		AllowAnyName:          data.Get("allow_any_name").(bool),
-		EnforceHostnames:      data.Get("enforce_hostnames").(bool),
-		AllowIPSANs:           data.Get("allow_ip_sans").(bool),
-		ServerFlag:            data.Get("server_flag").(bool),
-		ClientFlag:            data.Get("client_flag").(bool),
-		CodeSigningFlag:       data.Get("code_signing_flag").(bool),
-		KeyType:               data.Get("key_type").(string),
-		KeyBits:               data.Get("key_bits").(int),
+		MaxTTL:                    data.Get("max_ttl").(string),
+		TTL:                       data.Get("ttl").(string),
+		ServerMaxTTL:              data.Get("server_max_ttl").(string),
+		TTLClamp:                  data.Get("ttl_clamp").(bool),
+		TTLGranularity:            data.Get("ttl_granularity").(string),
+		RoundTTLToGranularity:     data.Get("round_ttl_to_granularity").(bool),
+		NotBeforeDuration:         data.Get("not_before_duration").(string),
+		AllowLocalhost:            data.Get("allow_localhost").(bool),
+		AllowedDomains:            subjectValues(data.Get("allowed_domains").(string)),
+		AllowGlobDomains:          data.Get("allow_glob_domains").(bool),
+		AllowTokenDisplayName:     data.Get("allow_token_displayname").(bool),
+		AllowSubdomains:           data.Get("allow_subdomains").(bool),
+		AllowWildcardCertificates: data.Get("allow_wildcard_certificates").(bool),
+		AllowAnyName:              data.Get("allow_any_name").(bool),
+		AllowEmptyCommonName:      data.Get("allow_empty_common_name").(bool),
+		RequireCNInAllowedDomains: data.Get("require_cn_in_allowed_domains").(bool),
+		ExcludeCNFromSANs:         data.Get("exclude_cn_from_sans").(bool),
+		EnforceHostnames:          data.Get("enforce_hostnames").(bool),
+		AllowIPSANs:               data.Get("allow_ip_sans").(bool),
+		AllowedIPAddresses:        data.Get("allowed_ip_addresses").(string),
+		AllowedIPSANs:             data.Get("allowed_ip_sans").(string),
+		AllowURISANs:              data.Get("allow_uri_sans").(bool),
+		AllowedURISANs:            data.Get("allowed_uri_sans").(string),
+		AllowedOtherSANs:          data.Get("allowed_other_sans").(string),
+		DefaultSANs:               data.Get("default_sans").(string),
+		MandatorySANSuffix:        strings.Trim(data.Get("mandatory_san_suffix").(string), "."),
+		MandatorySANAction:        data.Get("mandatory_san_action").(string),
+		MaxDNSNameLength:          data.Get("max_dns_name_length").(int),
+		MaxSANLength:              data.Get("max_san_length").(int),
+		AllowedOrganizations:      data.Get("allowed_organizations").(string),
+		DefaultOrganization:       data.Get("default_organization").(string),
+		DefaultOU:                 data.Get("default_ou").(string),
+		Country:                   strings.ToUpper(data.Get("country").(string)),
+		AllowedCountries:          strings.ToUpper(data.Get("allowed_countries").(string)),
+		Locality:                  data.Get("locality").(string),
+		Province:                  data.Get("province").(string),
+		PostalCode:                data.Get("postal_code").(string),
+		SingleCertPerCN:           data.Get("single_cert_per_cn").(bool),
+		UseSharedKey:              data.Get("use_shared_key").(bool),
+		OmitAuthorityKeyID:        data.Get("omit_authority_key_id").(bool),
+		CertificateComment:        data.Get("certificate_comment").(string),
+		CRLDistributionPoints:     data.Get("crl_distribution_points").(string),
+		OCSPServers:               data.Get("ocsp_servers").(string),
+		ServerFlag:                data.Get("server_flag").(bool),
+		ClientFlag:                data.Get("client_flag").(bool),
+		CodeSigningFlag:           data.Get("code_signing_flag").(bool),
+		RequiredExtKeyUsage:       data.Get("required_ext_key_usage").(string),
+		KeyUsage:                  data.Get("key_usage").(string),
+		PolicyIdentifiers:         data.Get("policy_identifiers").(string),
+		AllowPrecertificates:      data.Get("allow_precertificates").(bool),
+		KeyType:                   data.Get("key_type").(string),
+		KeyBits:                   data.Get("key_bits").(int),
+		SignatureBits:             data.Get("signature_bits").(int),
+		ECOnly:                    data.Get("ec_only").(bool),
+		AllowedECCurves:           data.Get("allowed_ec_curves").(string),
+		AllowedKeyTypes:           data.Get("allowed_key_types").(string),
+		MinRSAKeyBits:             data.Get("min_rsa_key_bits").(int),
+		MinECKeyBits:              data.Get("min_ec_key_bits").(int),
+		IssuanceWindow:            data.Get("issuance_window").(string),
+		IssuanceDays:              data.Get("issuance_days").(string),
+	}
+
+	if len(entry.AllowedDomains) == 0 {
+		entry.AllowedDomains = subjectValues(data.Get("allowed_base_domain").(string))
 	}
 
 	if len(entry.MaxTTL) == 0 {
@@ -292,6 +792,76 @@ func (b *backend) pathRoleCreate(
 		}
 	}
 
+	if len(entry.ServerMaxTTL) != 0 {
+		if _, err := time.ParseDuration(entry.ServerMaxTTL); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"Invalid server_max_ttl: %s", err)), nil
+		}
+	}
+
+	if len(entry.TTLGranularity) != 0 {
+		granularity, err := time.ParseDuration(entry.TTLGranularity)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"Invalid ttl_granularity: %s", err)), nil
+		}
+		if granularity <= 0 {
+			return logical.ErrorResponse("ttl_granularity must be greater than zero"), nil
+		}
+	}
+
+	if len(entry.NotBeforeDuration) != 0 {
+		notBeforeDuration, err := time.ParseDuration(entry.NotBeforeDuration)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"Invalid not_before_duration: %s", err)), nil
+		}
+		if notBeforeDuration < 0 {
+			return logical.ErrorResponse("not_before_duration cannot be negative"), nil
+		}
+	}
+
+	if entry.RequireCNInAllowedDomains && len(entry.AllowedDomains) == 0 {
+		return logical.ErrorResponse("require_cn_in_allowed_domains requires allowed_domains to be set"), nil
+	}
+
+	if len(entry.DefaultSANs) != 0 {
+		for _, v := range strings.Split(entry.DefaultSANs, ",") {
+			if len(strings.TrimSpace(v)) == 0 {
+				return logical.ErrorResponse("Empty value found in default_sans"), nil
+			}
+			if err := validateDefaultSAN(strings.TrimSpace(v)); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+	}
+
+	if len(entry.AllowedOrganizations) != 0 {
+		for _, v := range strings.Split(entry.AllowedOrganizations, ",") {
+			if len(strings.TrimSpace(v)) == 0 {
+				return logical.ErrorResponse("Empty value found in allowed_organizations"), nil
+			}
+		}
+	}
+
+	if len(entry.RequiredExtKeyUsage) != 0 {
+		if _, err := parseExtKeyUsages(entry.RequiredExtKeyUsage); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	if len(entry.KeyUsage) != 0 {
+		if _, err := parseKeyUsages(entry.KeyUsage); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	if len(entry.PolicyIdentifiers) != 0 {
+		if _, err := parsePolicyIdentifiers(entry.PolicyIdentifiers); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
 	if len(entry.KeyType) == 0 {
 		entry.KeyType = "rsa"
 	}
@@ -299,19 +869,126 @@ func (b *backend) pathRoleCreate(
 		entry.KeyBits = 2048
 	}
 
-	switch entry.KeyType {
-	case "rsa":
-	case "ec":
-		switch entry.KeyBits {
-		case 224:
-		case 256:
-		case 384:
-		case 521:
-		default:
-			return logical.ErrorResponse(fmt.Sprintf("Unsupported bit length for EC key: %d", entry.KeyBits)), nil
+	if err := validateKeyTypeBits(entry.KeyType, entry.KeyBits); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if entry.KeyType == "ed25519" {
+		// Ed25519 keys have a fixed size
+		entry.KeyBits = 0
+	}
+
+	if len(entry.AllowedIPAddresses) != 0 {
+		for _, v := range strings.Split(entry.AllowedIPAddresses, ",") {
+			if net.ParseIP(strings.TrimSpace(v)) == nil {
+				return logical.ErrorResponse(fmt.Sprintf("The value '%s' in allowed_ip_addresses is not a valid IP address", v)), nil
+			}
 		}
+	}
+
+	if len(entry.AllowedIPSANs) != 0 {
+		for _, v := range strings.Split(entry.AllowedIPSANs, ",") {
+			if _, _, err := net.ParseCIDR(strings.TrimSpace(v)); err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("The value '%s' in allowed_ip_sans is not a valid CIDR block", v)), nil
+			}
+		}
+	}
+
+	if len(entry.AllowedOtherSANs) != 0 {
+		for _, v := range strings.Split(entry.AllowedOtherSANs, ",") {
+			v = strings.TrimSpace(v)
+			if v == "*" {
+				continue
+			}
+			if _, err := parseOtherSAN(v); err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("Invalid allowed_other_sans: %s", err)), nil
+			}
+		}
+	}
+
+	switch entry.SignatureBits {
+	case 0:
+		entry.SignatureBits = 256
+	case 256, 384, 512:
 	default:
-		return logical.ErrorResponse(fmt.Sprintf("Unknown key type %s", entry.KeyType)), nil
+		return logical.ErrorResponse(fmt.Sprintf("Unsupported signature_bits: %d", entry.SignatureBits)), nil
+	}
+
+	for _, v := range subjectValues(entry.AllowedCountries) {
+		if !validCountryCode(v) {
+			return logical.ErrorResponse(fmt.Sprintf("Country %s in allowed_countries is not an ISO 3166-1 alpha-2 code", v)), nil
+		}
+	}
+	for _, v := range subjectValues(entry.Country) {
+		if !validCountryCode(v) {
+			return logical.ErrorResponse(fmt.Sprintf("Country %s is not an ISO 3166-1 alpha-2 code", v)), nil
+		}
+		if len(entry.AllowedCountries) != 0 && !countryAllowed(entry, v) {
+			return logical.ErrorResponse(fmt.Sprintf("Country %s is not in allowed_countries", v)), nil
+		}
+	}
+
+	switch entry.MandatorySANAction {
+	case "", "append", "reject":
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("Unknown mandatory_san_action: %s", entry.MandatorySANAction)), nil
+	}
+
+	if entry.MaxDNSNameLength < 0 || entry.MaxSANLength < 0 {
+		return logical.ErrorResponse("SAN length limits cannot be negative"), nil
+	}
+
+	// The extension is an IA5String
+	for _, c := range entry.CertificateComment {
+		if c > 0x7f {
+			return logical.ErrorResponse("certificate_comment must only contain ASCII characters"), nil
+		}
+	}
+
+	if _, err := parseURLList("crl_distribution_points", entry.CRLDistributionPoints); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if _, err := parseURLList("ocsp_servers", entry.OCSPServers); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if len(entry.AllowedECCurves) != 0 {
+		for _, v := range strings.Split(entry.AllowedECCurves, ",") {
+			switch strings.TrimSpace(v) {
+			case "P-224", "P-256", "P-384", "P-521":
+			default:
+				return logical.ErrorResponse(fmt.Sprintf("Unknown curve in allowed_ec_curves: %s", v)), nil
+			}
+		}
+	}
+
+	if entry.ECOnly {
+		if err := checkECOnlyKey(entry, entry.KeyType, entry.KeyBits); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	if len(entry.AllowedKeyTypes) != 0 {
+		for _, v := range strings.Split(entry.AllowedKeyTypes, ",") {
+			switch strings.TrimSpace(v) {
+			case "rsa", "ec", "ed25519":
+			default:
+				return logical.ErrorResponse(fmt.Sprintf("Unknown key type in allowed_key_types: %s", v)), nil
+			}
+		}
+	}
+	if entry.MinRSAKeyBits < 0 || entry.MinECKeyBits < 0 {
+		return logical.ErrorResponse("Minimum key sizes cannot be negative"), nil
+	}
+
+	if len(entry.IssuanceWindow) != 0 {
+		if _, _, err := parseIssuanceWindow(entry.IssuanceWindow); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+	if len(entry.IssuanceDays) != 0 {
+		if _, err := parseIssuanceDays(entry.IssuanceDays); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
 	}
 
 	// Store it
@@ -323,28 +1000,85 @@ func (b *backend) pathRoleCreate(
 		return nil, err
 	}
 
+	if entry.UseSharedKey {
+		resp := &logical.Response{}
+		resp.AddWarning(sharedKeyWarning)
+		return resp, nil
+	}
+
 	return nil, nil
 }
 
 type roleEntry struct {
-	LeaseMax              string `json:"lease_max" structs:"lease_max" mapstructure:"lease_max"`
-	Lease                 string `json:"lease" structs:"lease" mapstructure:"lease"`
-	MaxTTL                string `json:"max_ttl" structs:"max_ttl" mapstructure:"max_ttl"`
-	TTL                   string `json:"ttl" structs:"ttl" mapstructure:"ttl"`
-	AllowLocalhost        bool   `json:"allow_localhost" structs:"allow_localhost" mapstructure:"allow_localhost"`
-	AllowedBaseDomain     string `json:"allowed_base_domain" structs:"allowed_base_domain" mapstructure:"allowed_base_domain"`
-	AllowTokenDisplayName bool   `json:"allow_token_displayname" structs:"allow_token_displayname" mapstructure:"allow_token_displayname"`
-	AllowSubdomains       bool   `json:"allow_subdomains" structs:"allow_subdomains" mapstructure:"allow_subdomains"`
-	AllowAnyName          bool   `json:"allow_any_name" structs:"allow_any_name" mapstructure:"allow_any_name"`
-	EnforceHostnames      bool   `json:"enforce_hostnames" structs:"enforce_hostnames" mapstructure:"enforce_hostnames"`
-	AllowIPSANs           bool   `json:"allow_ip_sans" structs:"allow_ip_sans" mapstructure:"allow_ip_sans"`
-	ServerFlag            bool   `json:"server_flag" structs:"server_flag" mapstructure:"server_flag"`
-	ClientFlag            bool   `json:"client_flag" structs:"client_flag" mapstructure:"client_flag"`
-	CodeSigningFlag       bool   `json:"code_signing_flag" structs:"code_signing_flag" mapstructure:"code_signing_flag"`
-	KeyType               string `json:"key_type" structs:"key_type" mapstructure:"key_type"`
-	KeyBits               int    `json:"key_bits" structs:"key_bits" mapstructure:"key_bits"`
+	LeaseMax                  string   `json:"lease_max" structs:"lease_max" mapstructure:"lease_max"`
+	Lease                     string   `json:"lease" structs:"lease" mapstructure:"lease"`
+	MaxTTL                    string   `json:"max_ttl" structs:"max_ttl" mapstructure:"max_ttl"`
+	TTL                       string   `json:"ttl" structs:"ttl" mapstructure:"ttl"`
+	ServerMaxTTL              string   `json:"server_max_ttl" structs:"server_max_ttl" mapstructure:"server_max_ttl"`
+	TTLClamp                  bool     `json:"ttl_clamp" structs:"ttl_clamp" mapstructure:"ttl_clamp"`
+	TTLGranularity            string   `json:"ttl_granularity" structs:"ttl_granularity" mapstructure:"ttl_granularity"`
+	RoundTTLToGranularity     bool     `json:"round_ttl_to_granularity" structs:"round_ttl_to_granularity" mapstructure:"round_ttl_to_granularity"`
+	NotBeforeDuration         string   `json:"not_before_duration" structs:"not_before_duration" mapstructure:"not_before_duration"`
+	AllowLocalhost            bool     `json:"allow_localhost" structs:"allow_localhost" mapstructure:"allow_localhost"`
+	AllowedBaseDomain         string   `json:"allowed_base_domain" structs:"allowed_base_domain" mapstructure:"allowed_base_domain"`
+	AllowedDomains            []string `json:"allowed_domains" structs:"allowed_domains" mapstructure:"allowed_domains"`
+	AllowGlobDomains          bool     `json:"allow_glob_domains" structs:"allow_glob_domains" mapstructure:"allow_glob_domains"`
+	AllowTokenDisplayName     bool     `json:"allow_token_displayname" structs:"allow_token_displayname" mapstructure:"allow_token_displayname"`
+	AllowSubdomains           bool     `json:"allow_subdomains" structs:"allow_subdomains" mapstructure:"allow_subdomains"`
+	AllowWildcardCertificates bool     `json:"allow_wildcard_certificates" structs:"allow_wildcard_certificates" mapstructure:"allow_wildcard_certificates"`
+	AllowAnyName              bool     `json:"allow_any_name" structs:"allow_any_name" mapstructure:"allow_any_name"`
+	AllowEmptyCommonName      bool     `json:"allow_empty_common_name" structs:"allow_empty_common_name" mapstructure:"allow_empty_common_name"`
+	RequireCNInAllowedDomains bool     `json:"require_cn_in_allowed_domains" structs:"require_cn_in_allowed_domains" mapstructure:"require_cn_in_allowed_domains"`
+	ExcludeCNFromSANs         bool     `json:"exclude_cn_from_sans" structs:"exclude_cn_from_sans" mapstructure:"exclude_cn_from_sans"`
+	EnforceHostnames          bool     `json:"enforce_hostnames" structs:"enforce_hostnames" mapstructure:"enforce_hostnames"`
+	AllowIPSANs               bool     `json:"allow_ip_sans" structs:"allow_ip_sans" mapstructure:"allow_ip_sans"`
+	AllowedIPAddresses        string   `json:"allowed_ip_addresses" structs:"allowed_ip_addresses" mapstructure:"allowed_ip_addresses"`
+	AllowedIPSANs             string   `json:"allowed_ip_sans" structs:"allowed_ip_sans" mapstructure:"allowed_ip_sans"`
+	AllowURISANs              bool     `json:"allow_uri_sans" structs:"allow_uri_sans" mapstructure:"allow_uri_sans"`
+	AllowedURISANs            string   `json:"allowed_uri_sans" structs:"allowed_uri_sans" mapstructure:"allowed_uri_sans"`
+	AllowedOtherSANs          string   `json:"allowed_other_sans" structs:"allowed_other_sans" mapstructure:"allowed_other_sans"`
+	DefaultSANs               string   `json:"default_sans" structs:"default_sans" mapstructure:"default_sans"`
+	MandatorySANSuffix        string   `json:"mandatory_san_suffix" structs:"mandatory_san_suffix" mapstructure:"mandatory_san_suffix"`
+	MandatorySANAction        string   `json:"mandatory_san_action" structs:"mandatory_san_action" mapstructure:"mandatory_san_action"`
+	MaxDNSNameLength          int      `json:"max_dns_name_length" structs:"max_dns_name_length" mapstructure:"max_dns_name_length"`
+	MaxSANLength              int      `json:"max_san_length" structs:"max_san_length" mapstructure:"max_san_length"`
+	AllowedOrganizations      string   `json:"allowed_organizations" structs:"allowed_organizations" mapstructure:"allowed_organizations"`
+	DefaultOrganization       string   `json:"default_organization" structs:"default_organization" mapstructure:"default_organization"`
+	DefaultOU                 string   `json:"default_ou" structs:"default_ou" mapstructure:"default_ou"`
+	Country                   string   `json:"country" structs:"country" mapstructure:"country"`
+	AllowedCountries          string   `json:"allowed_countries" structs:"allowed_countries" mapstructure:"allowed_countries"`
+	Locality                  string   `json:"locality" structs:"locality" mapstructure:"locality"`
+	Province                  string   `json:"province" structs:"province" mapstructure:"province"`
+	PostalCode                string   `json:"postal_code" structs:"postal_code" mapstructure:"postal_code"`
+	SingleCertPerCN           bool     `json:"single_cert_per_cn" structs:"single_cert_per_cn" mapstructure:"single_cert_per_cn"`
+	UseSharedKey              bool     `json:"use_shared_key" structs:"use_shared_key" mapstructure:"use_shared_key"`
+	OmitAuthorityKeyID        bool     `json:"omit_authority_key_id" structs:"omit_authority_key_id" mapstructure:"omit_authority_key_id"`
+	CertificateComment        string   `json:"certificate_comment" structs:"certificate_comment" mapstructure:"certificate_comment"`
+	CRLDistributionPoints     string   `json:"crl_distribution_points" structs:"crl_distribution_points" mapstructure:"crl_distribution_points"`
+	OCSPServers               string   `json:"ocsp_servers" structs:"ocsp_servers" mapstructure:"ocsp_servers"`
+	ServerFlag                bool     `json:"server_flag" structs:"server_flag" mapstructure:"server_flag"`
+	ClientFlag                bool     `json:"client_flag" structs:"client_flag" mapstructure:"client_flag"`
+	CodeSigningFlag           bool     `json:"code_signing_flag" structs:"code_signing_flag" mapstructure:"code_signing_flag"`
+	RequiredExtKeyUsage       string   `json:"required_ext_key_usage" structs:"required_ext_key_usage" mapstructure:"required_ext_key_usage"`
+	KeyUsage                  string   `json:"key_usage" structs:"key_usage" mapstructure:"key_usage"`
+	PolicyIdentifiers         string   `json:"policy_identifiers" structs:"policy_identifiers" mapstructure:"policy_identifiers"`
+	AllowPrecertificates      bool     `json:"allow_precertificates" structs:"allow_precertificates" mapstructure:"allow_precertificates"`
+	KeyType                   string   `json:"key_type" structs:"key_type" mapstructure:"key_type"`
+	KeyBits                   int      `json:"key_bits" structs:"key_bits" mapstructure:"key_bits"`
+	SignatureBits             int      `json:"signature_bits" structs:"signature_bits" mapstructure:"signature_bits"`
+	ECOnly                    bool     `json:"ec_only" structs:"ec_only" mapstructure:"ec_only"`
+	AllowedECCurves           string   `json:"allowed_ec_curves" structs:"allowed_ec_curves" mapstructure:"allowed_ec_curves"`
+	AllowedKeyTypes           string   `json:"allowed_key_types" structs:"allowed_key_types" mapstructure:"allowed_key_types"`
+	MinRSAKeyBits             int      `json:"min_rsa_key_bits" structs:"min_rsa_key_bits" mapstructure:"min_rsa_key_bits"`
+	MinECKeyBits              int      `json:"min_ec_key_bits" structs:"min_ec_key_bits" mapstructure:"min_ec_key_bits"`
+	IssuanceWindow            string   `json:"issuance_window" structs:"issuance_window" mapstructure:"issuance_window"`
+	IssuanceDays              string   `json:"issuance_days" structs:"issuance_days" mapstructure:"issuance_days"`
 }
 
+// The CA/Browser Forum Baseline Requirements cap TLS server certificate
+// validity at 398 days
+const defaultServerMaxTTL = "9552h"
+
 const pathRoleHelpSyn = `
 Manage the roles that can be created with this backend.
 `
@@ -352,3 +1086,11 @@ Manage the roles that can be created with this backend.
 const pathRoleHelpDesc = `
 This path lets you manage the roles that can be created with this backend.
 `
+
+const pathListRolesHelpSyn = `
+List the existing roles in this backend.
+`
+
+const pathListRolesHelpDesc = `
+Roles are listed by name. Reading this path returns the same list.
+`
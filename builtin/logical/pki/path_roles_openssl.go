@@ -0,0 +1,211 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// The OpenSSL names of the extended key usages, in rendering order
+var openSSLExtKeyUsages = []struct {
+	usage certUsage
+	name  string
+}{
+	{serverUsage, "serverAuth"},
+	{clientUsage, "clientAuth"},
+	{codeSigningUsage, "codeSigning"},
+	{emailProtectionUsage, "emailProtection"},
+	{timeStampingUsage, "timeStamping"},
+	{ocspSigningUsage, "OCSPSigning"},
+}
+
+// The OpenSSL names of the key usages, in rendering order
+var openSSLKeyUsages = []struct {
+	keyUsage x509.KeyUsage
+	name     string
+}{
+	{x509.KeyUsageDigitalSignature, "digitalSignature"},
+	{x509.KeyUsageContentCommitment, "nonRepudiation"},
+	{x509.KeyUsageKeyEncipherment, "keyEncipherment"},
+	{x509.KeyUsageDataEncipherment, "dataEncipherment"},
+	{x509.KeyUsageKeyAgreement, "keyAgreement"},
+	{x509.KeyUsageCertSign, "keyCertSign"},
+	{x509.KeyUsageCRLSign, "cRLSign"},
+	{x509.KeyUsageEncipherOnly, "encipherOnly"},
+	{x509.KeyUsageDecipherOnly, "decipherOnly"},
+}
+
+func pathRoleOpenSSL(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex("name") + "/openssl",
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the role",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathRoleOpenSSLRead,
+		},
+
+		HelpSynopsis:    pathRoleOpenSSLHelpSyn,
+		HelpDescription: pathRoleOpenSSLHelpDesc,
+	}
+}
+
+func (b *backend) pathRoleOpenSSLRead(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	role, err := b.getRole(req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
+
+	config, err := renderOpenSSLConfig(name, role)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"config": config,
+		},
+	}, nil
+}
+
+// Renders the extensions that createCertificate sets for the role as an
+// OpenSSL x509v3 extensions section. Policy that OpenSSL cannot express,
+// such as the allowed names and TTLs, is rendered as comments.
+func renderOpenSSLConfig(name string, role *roleEntry) (string, error) {
+	usage, err := roleUsage(role)
+	if err != nil {
+		return "", err
+	}
+
+	ttl := role.TTL
+	if len(ttl) == 0 {
+		ttl = "(system default)"
+	}
+	maxTTL := role.MaxTTL
+	if len(maxTTL) == 0 {
+		maxTTL = "(system default)"
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Issuance policy of role %q\n", name)
+	fmt.Fprintf(&buf, "#\n")
+	fmt.Fprintf(&buf, "# ttl = %s\n", ttl)
+	fmt.Fprintf(&buf, "# max_ttl = %s\n", maxTTL)
+	fmt.Fprintf(&buf, "# key_type = %s\n", role.KeyType)
+	fmt.Fprintf(&buf, "# key_bits = %d\n", role.KeyBits)
+	fmt.Fprintf(&buf, "# allowed_domains = %s\n", strings.Join(role.AllowedDomains, ","))
+	fmt.Fprintf(&buf, "# allow_subdomains = %t\n", role.AllowSubdomains)
+	fmt.Fprintf(&buf, "# allow_localhost = %t\n", role.AllowLocalhost)
+	fmt.Fprintf(&buf, "# allow_any_name = %t\n", role.AllowAnyName)
+	fmt.Fprintf(&buf, "# enforce_hostnames = %t\n", role.EnforceHostnames)
+	fmt.Fprintf(&buf, "# allow_ip_sans = %t\n", role.AllowIPSANs)
+
+	fmt.Fprintf(&buf, "\n[ %s_ext ]\n", name)
+	fmt.Fprintf(&buf, "basicConstraints = critical, CA:FALSE\n")
+	keyUsage := x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement
+	if len(role.KeyUsage) != 0 {
+		keyUsage, err = parseKeyUsages(role.KeyUsage)
+		if err != nil {
+			return "", err
+		}
+	}
+	var keyUsages []string
+	for _, v := range openSSLKeyUsages {
+		if keyUsage&v.keyUsage != 0 {
+			keyUsages = append(keyUsages, v.name)
+		}
+	}
+	fmt.Fprintf(&buf, "keyUsage = critical, %s\n", strings.Join(keyUsages, ", "))
+
+	var extKeyUsages []string
+	for _, v := range openSSLExtKeyUsages {
+		if usage&v.usage != 0 {
+			extKeyUsages = append(extKeyUsages, v.name)
+		}
+	}
+	if len(extKeyUsages) != 0 {
+		fmt.Fprintf(&buf, "extendedKeyUsage = %s\n", strings.Join(extKeyUsages, ", "))
+	}
+
+	if len(role.PolicyIdentifiers) != 0 {
+		policyIdentifiers, err := parsePolicyIdentifiers(role.PolicyIdentifiers)
+		if err != nil {
+			return "", err
+		}
+		var policies []string
+		for _, v := range policyIdentifiers {
+			policies = append(policies, v.String())
+		}
+		fmt.Fprintf(&buf, "certificatePolicies = %s\n", strings.Join(policies, ", "))
+	}
+
+	fmt.Fprintf(&buf, "subjectKeyIdentifier = hash\n")
+	if !role.OmitAuthorityKeyID {
+		fmt.Fprintf(&buf, "authorityKeyIdentifier = keyid\n")
+	}
+
+	if len(role.CertificateComment) != 0 {
+		fmt.Fprintf(&buf, "nsComment = %q\n", role.CertificateComment)
+	}
+
+	var organization, ou []string
+	if len(role.DefaultOrganization) != 0 {
+		organization = []string{role.DefaultOrganization}
+	}
+	if len(role.DefaultOU) != 0 {
+		ou = []string{role.DefaultOU}
+	}
+	dn := []struct {
+		name   string
+		values []string
+	}{
+		{"C", subjectValues(role.Country)},
+		{"ST", subjectValues(role.Province)},
+		{"L", subjectValues(role.Locality)},
+		{"postalCode", subjectValues(role.PostalCode)},
+		{"O", organization},
+		{"OU", ou},
+	}
+
+	var dnLines []string
+	for _, attribute := range dn {
+		for i, v := range attribute.values {
+			// OpenSSL needs repeated attributes to be numbered
+			if len(attribute.values) > 1 {
+				dnLines = append(dnLines, fmt.Sprintf("%d.%s = %s", i, attribute.name, v))
+			} else {
+				dnLines = append(dnLines, fmt.Sprintf("%s = %s", attribute.name, v))
+			}
+		}
+	}
+	if len(dnLines) != 0 {
+		fmt.Fprintf(&buf, "\n[ %s_dn ]\n%s\n", name, strings.Join(dnLines, "\n"))
+	}
+
+	return buf.String(), nil
+}
+
+const pathRoleOpenSSLHelpSyn = `
+Render a role's issuance policy as OpenSSL configuration.
+`
+
+const pathRoleOpenSSLHelpDesc = `
+This endpoint renders the extensions of certificates issued by the role
+as an OpenSSL x509v3 extensions section, for cross-checking against
+external tooling. Default subject values are rendered as a separate
+distinguished name section. Settings that OpenSSL cannot express, such
+as the allowed names and TTLs, are included as comments.
+`
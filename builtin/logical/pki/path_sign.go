@@ -0,0 +1,97 @@
+package pki
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func pathSign(b *backend) *framework.Path {
+	ret := &framework.Path{
+		Pattern: "sign/" + framework.GenericNameRegex("role"),
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.WriteOperation: b.pathSignCert,
+		},
+
+		HelpSynopsis:    pathSignCertHelpSyn,
+		HelpDescription: pathSignCertHelpDesc,
+	}
+
+	// Signing takes the same options as issuance, other than those for
+	// the private key, which is never seen by the backend, and those for
+	// final certificates, which are issued from their precertificates
+	ret.Fields = pathIssue(b).Fields
+	delete(ret.Fields, "private_key_format")
+	delete(ret.Fields, "precertificate_serial")
+	delete(ret.Fields, "scts")
+
+	ret.Fields["csr"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `The PEM-format certificate signing request`,
+	}
+	ret.Fields["common_name"] = &framework.FieldSchema{
+		Type: framework.TypeString,
+		Description: `The requested common name; defaults to the
+common name of the CSR`,
+	}
+	ret.Fields["alt_names"] = &framework.FieldSchema{
+		Type: framework.TypeString,
+		Description: `The requested Subject Alternative Names, if any,
+in a comma-delimited list; defaults to the DNS
+names of the CSR`,
+	}
+	ret.Fields["ip_sans"] = &framework.FieldSchema{
+		Type: framework.TypeString,
+		Description: `The requested IP SANs, if any, in a
+comma-delimited list; defaults to the IP
+addresses of the CSR`,
+	}
+	ret.Fields["uri_sans"] = &framework.FieldSchema{
+		Type: framework.TypeString,
+		Description: `The requested URI SANs, if any, in a
+comma-delimited list; defaults to the URIs of
+the CSR`,
+	}
+
+	return ret
+}
+
+func (b *backend) pathSignCert(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.signCert(req, data, time.Time{})
+}
+
+// Signs the CSR of the request; leaseIssued is as for pathIssueSignCert
+func (b *backend) signCert(
+	req *logical.Request, data *framework.FieldData, leaseIssued time.Time) (*logical.Response, error) {
+	csr, err := parseCSR(data.Get("csr").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	switch keyType, _ := publicKeyTypeBits(csr.PublicKey); keyType {
+	case "rsa", "ec", "ed25519":
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("Unsupported public key type in CSR: %s", keyType)), nil
+	}
+
+	return b.pathIssueSignCert(req, data, csr, leaseIssued)
+}
+
+const pathSignCertHelpSyn = `
+Request a certificate for a CSR using a certain role.
+`
+
+const pathSignCertHelpDesc = `
+This path signs a certificate signing request according to the policy of
+the given role, much as "issue" does, except that the certificate is
+issued for the public key of the request and no private key is returned.
+
+The common name and Subject Alternative Names are taken from the request
+unless given explicitly, and are checked against the role either way. No
+other attributes or extensions of the request are carried over; the rest
+of the certificate is built from the role, as for issuance.
+`
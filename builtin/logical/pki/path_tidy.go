@@ -0,0 +1,215 @@
+package pki
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func pathTidy(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "tidy",
+		Fields: map[string]*framework.FieldSchema{
+			"safety_buffer": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "72h",
+				Description: `The amount of time a certificate must have been
+expired before it is removed; defaults to 72h`,
+			},
+
+			"tidy_revoked": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If set, revocation entries of expired
+certificates are removed as well; defaults to false`,
+			},
+
+			"pending_csr_max_age": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "720h",
+				Description: `The amount of time after which keys generated
+by generate-csr that were never installed are
+removed; defaults to 720h`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.WriteOperation: b.pathTidyWrite,
+		},
+
+		HelpSynopsis:    pathTidyHelpSyn,
+		HelpDescription: pathTidyHelpDesc,
+	}
+}
+
+func (b *backend) pathTidyWrite(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	safetyBuffer, err := time.ParseDuration(data.Get("safety_buffer").(string))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("Invalid safety_buffer: %s", err)), nil
+	}
+	if safetyBuffer < 0 {
+		return logical.ErrorResponse("The safety_buffer cannot be negative"), nil
+	}
+
+	pendingCSRMaxAge, err := time.ParseDuration(data.Get("pending_csr_max_age").(string))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("Invalid pending_csr_max_age: %s", err)), nil
+	}
+	if pendingCSRMaxAge < 0 {
+		return logical.ErrorResponse("The pending_csr_max_age cannot be negative"), nil
+	}
+
+	// Revocation moves entries between the two prefixes, so neither can
+	// change underneath us
+	b.revokeStorageLock.Lock()
+	defer b.revokeStorageLock.Unlock()
+
+	cutoff := b.clock().Add(-safetyBuffer)
+
+	certsDeleted, err := tidyCerts(req, "certs/", cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	precertsDeleted, err := tidyCerts(req, "precerts/", cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	revokedDeleted := 0
+	if data.Get("tidy_revoked").(bool) {
+		revokedDeleted, err = tidyCerts(req, "revoked/", cutoff)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pendingCSRsDeleted, err := b.tidyPendingCSRs(req, b.clock().Add(-pendingCSRMaxAge))
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"certs_deleted":        certsDeleted,
+			"precerts_deleted":     precertsDeleted,
+			"revoked_deleted":      revokedDeleted,
+			"pending_csrs_deleted": pendingCSRsDeleted,
+		},
+	}, nil
+}
+
+// Deletes the entries under the given prefix whose certificates expired
+// before the cutoff, returning how many were deleted. Entries under
+// revoked/ and precerts/ hold the certificate within their JSON info.
+func tidyCerts(req *logical.Request, prefix string, cutoff time.Time) (int, error) {
+	serials, err := req.Storage.List(prefix)
+	if err != nil {
+		return 0, fmt.Errorf("Error fetching list of %s entries: %s", prefix, err)
+	}
+
+	deleted := 0
+	for _, serial := range serials {
+		entry, err := req.Storage.Get(prefix + serial)
+		if err != nil {
+			return deleted, fmt.Errorf("Error fetching %s entry for serial %s: %s", prefix, serial, err)
+		}
+		if entry == nil {
+			continue
+		}
+
+		certBytes := entry.Value
+		switch prefix {
+		case "revoked/":
+			var revInfo revocationInfo
+			if err := entry.DecodeJSON(&revInfo); err != nil {
+				return deleted, fmt.Errorf("Error decoding revocation entry for serial %s: %s", serial, err)
+			}
+			certBytes = revInfo.CertificateBytes
+		case "precerts/":
+			var precertInfo precertificateInfo
+			if err := entry.DecodeJSON(&precertInfo); err != nil {
+				return deleted, fmt.Errorf("Error decoding precertificate entry for serial %s: %s", serial, err)
+			}
+			certBytes = precertInfo.CertificateBytes
+		}
+
+		cert, err := x509.ParseCertificate(certBytes)
+		if err != nil {
+			return deleted, fmt.Errorf("Unable to parse stored certificate with serial %s: %s", serial, err)
+		}
+
+		if !cert.NotAfter.Before(cutoff) {
+			continue
+		}
+
+		if err := req.Storage.Delete(prefix + serial); err != nil {
+			return deleted, fmt.Errorf("Error deleting %s entry for serial %s: %s", prefix, serial, err)
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// Deletes the pending CSRs whose keys were generated before the cutoff,
+// returning how many were deleted. Entries from before the generation time
+// was recorded have none, and are always deleted.
+func (b *backend) tidyPendingCSRs(req *logical.Request, cutoff time.Time) (int, error) {
+	b.pendingCSRLock.Lock()
+	defer b.pendingCSRLock.Unlock()
+
+	handles, err := req.Storage.List("csr/pending/")
+	if err != nil {
+		return 0, fmt.Errorf("Error fetching list of pending CSRs: %s", err)
+	}
+
+	deleted := 0
+	for _, handle := range handles {
+		entry, err := req.Storage.Get("csr/pending/" + handle)
+		if err != nil {
+			return deleted, fmt.Errorf("Error fetching pending CSR %s: %s", handle, err)
+		}
+		if entry == nil {
+			continue
+		}
+
+		var pending pendingCSREntry
+		if err := entry.DecodeJSON(&pending); err != nil {
+			return deleted, fmt.Errorf("Error decoding pending CSR %s: %s", handle, err)
+		}
+		if !pending.CreatedAt.Before(cutoff) {
+			continue
+		}
+
+		if err := req.Storage.Delete("csr/pending/" + handle); err != nil {
+			return deleted, fmt.Errorf("Error deleting pending CSR %s: %s", handle, err)
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+const pathTidyHelpSyn = `
+Remove expired certificates from storage.
+`
+
+const pathTidyHelpDesc = `
+Every issued certificate is stored under its serial number so that it can
+be fetched and revoked. This endpoint removes the stored certificates that
+expired more than "safety_buffer" ago, 72h by default, and returns how many
+were removed. A root token is required. Expired precertificates that no
+certificate was issued from are removed as well.
+
+Keys generated by "generate-csr" that were never installed are removed
+once they are older than "pending_csr_max_age", 720h by default.
+
+If "tidy_revoked" is set, the revocation entries of such certificates are
+removed as well. Expired certificates are already left out of the CRL, so
+this only reclaims storage; afterwards, they can no longer be fetched by
+serial number.
+`
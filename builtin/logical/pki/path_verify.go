@@ -0,0 +1,119 @@
+package pki
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/helper/certutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func pathVerify(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "verify",
+		Fields: map[string]*framework.FieldSchema{
+			"certificate": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `PEM-format certificate to verify`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.WriteOperation: b.pathVerifyWrite,
+		},
+
+		HelpSynopsis:    pathVerifyHelpSyn,
+		HelpDescription: pathVerifyHelpDesc,
+	}
+}
+
+func (b *backend) pathVerifyWrite(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	pemBlock, _ := pem.Decode([]byte(data.Get("certificate").(string)))
+	if pemBlock == nil || pemBlock.Type != "CERTIFICATE" {
+		return logical.ErrorResponse("A PEM-format certificate must be provided"), nil
+	}
+	cert, err := x509.ParseCertificate(pemBlock.Bytes)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("Unable to parse certificate: %s", err)), nil
+	}
+
+	signingBundle, caErr := b.fetchCAInfo(req)
+	switch caErr.(type) {
+	case certutil.UserError:
+		return logical.ErrorResponse(fmt.Sprintf("Could not fetch the CA certificate: %s", caErr)), nil
+	case certutil.InternalError:
+		return nil, fmt.Errorf("Error fetching CA certificate: %s", caErr)
+	}
+
+	now := b.clock()
+	expired := now.After(cert.NotAfter)
+	notYetValid := now.Before(cert.NotBefore)
+
+	// The chain is checked at a time the certificate is valid, so that
+	// an expired certificate is still reported as issued by this CA
+	verifyTime := now
+	if expired {
+		verifyTime = cert.NotAfter
+	} else if notYetValid {
+		verifyTime = cert.NotBefore
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(signingBundle.Certificate)
+	_, verifyErr := cert.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: verifyTime,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+
+	serial := certutil.GetOctalFormatted(cert.SerialNumber.Bytes(), ":")
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"serial_number": serial,
+			"chains_to_ca":  verifyErr == nil,
+			"expired":       expired,
+			"not_yet_valid": notYetValid,
+			"revoked":       false,
+		},
+	}
+	if verifyErr != nil {
+		resp.Data["verification_error"] = verifyErr.Error()
+	}
+
+	// Revocation is only meaningful for certificates of this CA
+	if verifyErr == nil {
+		revokedEntry, err := req.Storage.Get("revoked/" + serial)
+		if err != nil {
+			return nil, fmt.Errorf("Error fetching revocation info: %s", err)
+		}
+		if revokedEntry != nil {
+			var revInfo revocationInfo
+			if err := revokedEntry.DecodeJSON(&revInfo); err != nil {
+				return nil, fmt.Errorf("Error decoding revocation info for serial %s: %s", serial, err)
+			}
+			resp.Data["revoked"] = true
+			resp.Data["revocation_time"] = time.Unix(revInfo.RevocationTime, 0).UTC().Format(time.RFC3339)
+		}
+	}
+
+	resp.Data["valid"] = verifyErr == nil && !expired && !notYetValid && !resp.Data["revoked"].(bool)
+
+	return resp, nil
+}
+
+const pathVerifyHelpSyn = `
+Check whether a certificate is valid under this backend's CA.
+`
+
+const pathVerifyHelpDesc = `
+This endpoint checks that the given PEM-format certificate chains to the
+CA certificate of this backend, and reports whether it is expired, not
+yet valid, or revoked. It is "valid" only if none of these apply. The
+chain is checked at a time within the certificate's validity period, so
+an expired certificate still reports whether it was issued by this CA.
+Nothing is stored.
+`
@@ -35,10 +35,92 @@ reference`,
 		DefaultDuration:    168 * time.Hour,
 		DefaultGracePeriod: 10 * time.Minute,
 
+		Renew:  b.secretCredsRenew,
 		Revoke: b.secretCredsRevoke,
 	}
 }
 
+// Renewing a certificate lease re-issues the certificate with a new key
+// pair, since private keys are never stored, and revokes the certificate it
+// supersedes; certificates signed from a CSR are signed again for the same
+// key. The names from the original request are checked against the role
+// again, so renewal fails if the role no longer allows them. The lease as
+// a whole cannot outlive the role's max_ttl.
+func (b *backend) secretCredsRenew(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if req.Secret == nil {
+		return nil, fmt.Errorf("Secret is nil in request")
+	}
+
+	serialInt, ok := req.Secret.InternalData["serial_number"]
+	if !ok {
+		return nil, fmt.Errorf("Could not find serial in internal secret data")
+	}
+
+	serial := strings.Replace(strings.ToLower(serialInt.(string)), "-", ":", -1)
+
+	roleName, ok := req.Secret.InternalData["role"].(string)
+	if !ok {
+		return logical.ErrorResponse("This certificate was issued before lease renewal was supported and cannot be renewed"), nil
+	}
+
+	certEntry, err := req.Storage.Get("certs/" + serial)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching certificate with serial %s: %s", serial, err)
+	}
+	if certEntry == nil {
+		return logical.ErrorResponse(fmt.Sprintf("Certificate with serial %s has been revoked and cannot be renewed", serial)), nil
+	}
+
+	issueData := map[string]interface{}{
+		"role": roleName,
+	}
+	for _, k := range []string{"common_name", "alt_names", "ip_sans", "uri_sans", "other_sans", "organization", "country", "private_key_format", "csr"} {
+		if v, ok := req.Secret.InternalData[k]; ok {
+			issueData[k] = v
+		}
+	}
+	if v, ok := req.Secret.InternalData["subject_serial_number"]; ok {
+		issueData["serial_number"] = v
+	}
+	if req.Secret.Increment > 0 {
+		issueData["ttl"] = req.Secret.Increment.String()
+	}
+
+	// Signed certificates are renewed by signing the same request again
+	var resp *logical.Response
+	if _, ok := issueData["csr"]; ok {
+		resp, err = b.signCert(req, &framework.FieldData{
+			Raw:    issueData,
+			Schema: pathSign(b).Fields,
+		}, req.Secret.IssueTime)
+	} else {
+		resp, err = b.pathIssueSignCert(req, &framework.FieldData{
+			Raw:    issueData,
+			Schema: pathIssue(b).Fields,
+		}, nil, req.Secret.IssueTime)
+	}
+	if err != nil || resp.IsError() {
+		return resp, err
+	}
+
+	b.revokeStorageLock.Lock()
+	defer b.revokeStorageLock.Unlock()
+
+	revokeResp, err := revokeCert(b, req, serial)
+	if err != nil || revokeResp.IsError() {
+		// The lease keeps the superseded certificate, so the replacement
+		// would be valid without anything tracking it
+		newSerial := resp.Data["serial_number"].(string)
+		if newResp, newErr := revokeCert(b, req, newSerial); newErr != nil || newResp.IsError() {
+			return nil, fmt.Errorf("Error revoking certificate %s, and then its replacement %s", serial, newSerial)
+		}
+		return revokeResp, err
+	}
+
+	return resp, nil
+}
+
 func (b *backend) secretCredsRevoke(
 	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	if req.Secret == nil {
@@ -55,5 +137,21 @@ func (b *backend) secretCredsRevoke(
 	b.revokeStorageLock.Lock()
 	defer b.revokeStorageLock.Unlock()
 
+	// An expired certificate may have been removed by tidy before its
+	// lease was revoked, in which case there is nothing left to do
+	certEntry, err := req.Storage.Get("certs/" + serial)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching certificate: %s", err)
+	}
+	if certEntry == nil {
+		revokedEntry, err := req.Storage.Get("revoked/" + serial)
+		if err != nil {
+			return nil, fmt.Errorf("Error fetching revocation info: %s", err)
+		}
+		if revokedEntry == nil {
+			return nil, nil
+		}
+	}
+
 	return revokeCert(b, req, serial)
 }
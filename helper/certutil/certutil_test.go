@@ -2,10 +2,19 @@ package certutil
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"math/big"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/fatih/structs"
 	"github.com/hashicorp/vault/api"
@@ -147,6 +156,101 @@ func TestCertBundleParsing(t *testing.T) {
 	}
 }
 
+// Tests that Ed25519 keys, which are PKCS #8-encoded, survive conversion
+// between the bundle types and are picked up by ParsePEMBundle
+func TestEd25519PrivateKey(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Error marshaling key: %s", err)
+	}
+	keyPem := strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: keyBytes,
+	})))
+
+	// The key type is detected when not given
+	cbut := &CertBundle{
+		PrivateKey: keyPem,
+	}
+	pcbut, err := cbut.ToParsedCertBundle()
+	if err != nil {
+		t.Fatalf("Error converting to parsed cert bundle: %s", err)
+	}
+	if pcbut.PrivateKeyType != Ed25519PrivateKey || cbut.PrivateKeyType != "ed25519" {
+		t.Fatalf("Parsed bundle has wrong private key type")
+	}
+	if !key.Equal(pcbut.PrivateKey) {
+		t.Fatalf("Parsed bundle private key does not match")
+	}
+
+	cb, err := pcbut.ToCertBundle()
+	if err != nil {
+		t.Fatalf("Error converting parsed bundle: %s", err)
+	}
+	if cb.PrivateKeyType != "ed25519" || cb.PrivateKey != keyPem {
+		t.Fatalf("Bundle private key does not match")
+	}
+
+	pcbut, err = ParsePEMBundle(keyPem)
+	if err != nil {
+		t.Fatalf("Error parsing PEM bundle: %s", err)
+	}
+	if pcbut.PrivateKeyType != Ed25519PrivateKey || !key.Equal(pcbut.PrivateKey) {
+		t.Fatalf("PEM bundle private key does not match")
+	}
+}
+
+func TestParsePEMBundleCAChain(t *testing.T) {
+	createCA := func(commonName string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*ecdsa.PrivateKey, *x509.Certificate, string) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Error generating key: %s", err)
+		}
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: commonName},
+			NotBefore:             time.Now(),
+			NotAfter:              time.Now().Add(time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		if parent == nil {
+			parent, parentKey = template, key
+		}
+		certBytes, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+		if err != nil {
+			t.Fatalf("Error creating certificate: %s", err)
+		}
+		cert, err := x509.ParseCertificate(certBytes)
+		if err != nil {
+			t.Fatalf("Error parsing certificate: %s", err)
+		}
+		return key, cert, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}))
+	}
+
+	rootKey, root, rootPEM := createCA("Root", nil, nil)
+	_, intermediate, intermediatePEM := createCA("Intermediate", root, rootKey)
+
+	// The intermediate is the certificate regardless of the order
+	for _, pemBundle := range []string{intermediatePEM + rootPEM, rootPEM + intermediatePEM} {
+		pcbut, err := ParsePEMBundle(pemBundle)
+		if err != nil {
+			t.Fatalf("Error parsing PEM bundle: %s", err)
+		}
+		if pcbut.Certificate == nil || !pcbut.Certificate.Equal(intermediate) {
+			t.Fatalf("Expected the intermediate to be the certificate")
+		}
+		if pcbut.IssuingCA == nil || !pcbut.IssuingCA.Equal(root) {
+			t.Fatalf("Expected the root to be the issuing CA")
+		}
+	}
+}
+
 func compareCertBundleToParsedCertBundle(cbut *CertBundle, pcbut *ParsedCertBundle) error {
 	if cbut == nil {
 		return fmt.Errorf("Got nil bundle")
@@ -50,7 +50,18 @@ func GetSubjKeyID(privateKey crypto.Signer) ([]byte, error) {
 		return nil, InternalError{"Passed-in private key is nil"}
 	}
 
-	marshaledKey, err := x509.MarshalPKIXPublicKey(privateKey.Public())
+	return GetSubjKeyIDFromPublicKey(privateKey.Public())
+}
+
+// GetSubjKeyIDFromPublicKey returns the subject key ID for the given public
+// key, for when the private key is not available, such as when signing a
+// CSR
+func GetSubjKeyIDFromPublicKey(publicKey crypto.PublicKey) ([]byte, error) {
+	if publicKey == nil {
+		return nil, InternalError{"Passed-in public key is nil"}
+	}
+
+	marshaledKey, err := x509.MarshalPKIXPublicKey(publicKey)
 	if err != nil {
 		return nil, InternalError{fmt.Sprintf("Error marshalling public key: %s", err)}
 	}
@@ -131,6 +142,14 @@ func ParsePEMBundle(pemBundle string) (*ParsedCertBundle, error) {
 			parsedBundle.PrivateKeyBytes = pemBlock.Bytes
 			parsedBundle.PrivateKey = signer
 
+		} else if signer, err := parseEd25519PrivateKey(pemBlock.Bytes); err == nil {
+			if parsedBundle.PrivateKeyType != UnknownPrivateKey {
+				return nil, UserError{"More than one private key given; provide only one private key in the bundle"}
+			}
+			parsedBundle.PrivateKeyType = Ed25519PrivateKey
+			parsedBundle.PrivateKeyBytes = pemBlock.Bytes
+			parsedBundle.PrivateKey = signer
+
 		} else if certificates, err := x509.ParseCertificates(pemBlock.Bytes); err == nil {
 			switch len(certificates) {
 			case 0:
@@ -153,6 +172,20 @@ func ParsePEMBundle(pemBundle string) (*ParsedCertBundle, error) {
 					}
 				} else {
 					switch {
+					// Two CA certificates, such as an intermediate and the
+					// CA that issued it; the former becomes the certificate
+					case parsedBundle.IssuingCA != nil && certificates[0].IsCA &&
+						bytes.Equal(parsedBundle.IssuingCA.AuthorityKeyId, certificates[0].SubjectKeyId):
+						parsedBundle.CertificateBytes = parsedBundle.IssuingCABytes
+						parsedBundle.Certificate = parsedBundle.IssuingCA
+						parsedBundle.IssuingCABytes = pemBlock.Bytes
+						parsedBundle.IssuingCA = certificates[0]
+
+					case parsedBundle.IssuingCA != nil && certificates[0].IsCA &&
+						bytes.Equal(parsedBundle.IssuingCA.SubjectKeyId, certificates[0].AuthorityKeyId):
+						parsedBundle.CertificateBytes = pemBlock.Bytes
+						parsedBundle.Certificate = certificates[0]
+
 					// If this case isn't correct, the caller needs to assign
 					// the values to Certificate/CertificateBytes; assumptions
 					// made here will not be valid for all cases.
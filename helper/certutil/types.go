@@ -10,6 +10,7 @@ package certutil
 
 import (
 	"crypto"
+	"crypto/ed25519"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
@@ -35,6 +36,7 @@ const (
 	UnknownPrivateKey = iota
 	RSAPrivateKey
 	ECPrivateKey
+	Ed25519PrivateKey
 
 	TLSUnknown TLSUsage = 0
 	TLSServer  TLSUsage = 1 << iota
@@ -102,6 +104,8 @@ func (c *CertBundle) ToParsedCertBundle() (*ParsedCertBundle, error) {
 			result.PrivateKeyType = ECPrivateKey
 		case "rsa":
 			result.PrivateKeyType = RSAPrivateKey
+		case "ed25519":
+			result.PrivateKeyType = Ed25519PrivateKey
 		default:
 			// Try to figure it out and correct
 			if _, err := x509.ParseECPrivateKey(pemBlock.Bytes); err == nil {
@@ -110,6 +114,9 @@ func (c *CertBundle) ToParsedCertBundle() (*ParsedCertBundle, error) {
 			} else if _, err := x509.ParsePKCS1PrivateKey(pemBlock.Bytes); err == nil {
 				result.PrivateKeyType = RSAPrivateKey
 				c.PrivateKeyType = "rsa"
+			} else if _, err := parseEd25519PrivateKey(pemBlock.Bytes); err == nil {
+				result.PrivateKeyType = Ed25519PrivateKey
+				c.PrivateKeyType = "ed25519"
 			} else {
 				return nil, UserError{fmt.Sprintf("Unknown private key type in bundle: %s", c.PrivateKeyType)}
 			}
@@ -183,6 +190,9 @@ func (p *ParsedCertBundle) ToCertBundle() (*CertBundle, error) {
 		case ECPrivateKey:
 			result.PrivateKeyType = "ec"
 			block.Type = "EC PRIVATE KEY"
+		case Ed25519PrivateKey:
+			result.PrivateKeyType = "ed25519"
+			block.Type = "PRIVATE KEY"
 		default:
 			return nil, InternalError{"Could not determine private key type when creating block"}
 		}
@@ -217,12 +227,32 @@ func (p *ParsedCertBundle) getSigner() (crypto.Signer, error) {
 			return nil, UserError{fmt.Sprintf("Unable to parse CA's private RSA key: %s", err)}
 		}
 
+	case Ed25519PrivateKey:
+		signer, err = parseEd25519PrivateKey(p.PrivateKeyBytes)
+		if err != nil {
+			return nil, UserError{fmt.Sprintf("Unable to parse private Ed25519 key: %s", err)}
+		}
+
 	default:
-		return nil, UserError{"Unable to determine type of private key; only RSA and EC are supported"}
+		return nil, UserError{"Unable to determine type of private key; only RSA, EC, and Ed25519 are supported"}
 	}
 	return signer, nil
 }
 
+// parseEd25519PrivateKey parses a PKCS #8 private key, which is the only
+// standard encoding for Ed25519 keys, and fails if it is of another type
+func parseEd25519PrivateKey(der []byte) (ed25519.PrivateKey, error) {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("PKCS #8 private key is not an Ed25519 key")
+	}
+	return edKey, nil
+}
+
 // GetTLSConfig returns a TLS config generally suitable for client
 // authentiation. The returned TLS config can be modified slightly
 // to be made suitable for a server requiring client authentication;